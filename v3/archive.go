@@ -0,0 +1,225 @@
+package gotoolkit
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// ArchiveOptions configures the extraction of uploaded .zip and .tar.gz archives when
+// Tools.ExtractArchives is set. Each entry in an archive is checked and saved as if it had been
+// uploaded on its own, so AllowedFileTypes, MaxFileSize, MaxUploadSize and MaxUploadFiles all apply
+// to the entries; the archive itself is not saved.
+type ArchiveOptions struct {
+	// MaxEntries limits the number of entries in an archive. Defaults to 1000.
+	MaxEntries int
+	// MaxSize limits the total uncompressed size of an archive. Zero means no limit other than
+	// MaxRatio and the usual upload limits.
+	MaxSize int64
+	// MaxRatio limits how many times larger than the archive its uncompressed content may be,
+	// to protect against decompression bombs. Defaults to 100. Archives are always allowed to
+	// expand to at least one megabyte.
+	MaxRatio float64
+}
+
+// ArchiveError is returned when an uploaded archive, or one of its entries, is rejected
+type ArchiveError struct {
+	Archive string
+	Entry   string
+	Reason  string
+}
+
+func (e *ArchiveError) Error() string {
+	if e.Entry == "" {
+		return fmt.Sprintf("the uploaded archive %s was rejected: %s", e.Archive, e.Reason)
+	}
+	return fmt.Sprintf("the uploaded archive %s was rejected: %s: %s", e.Archive, e.Entry, e.Reason)
+}
+
+// isArchive reports whether fileType is an archive format that can be extracted
+func isArchive(fileType string) bool {
+	return fileType == "application/zip" || fileType == "application/x-gzip"
+}
+
+// archiveExtraction tracks the limits of a single archive as its entries are extracted
+type archiveExtraction struct {
+	u       *uploader
+	part    *uploadPart
+	entries int
+	read    int64
+	max     int64
+}
+
+// extract saves every entry of the archive read from r, in place of the archive itself. A gzip file
+// that does not contain a tar archive is saved as an ordinary file.
+func (u *uploader) extract(p *uploadPart, r io.Reader, fileType string) error {
+	opts := u.t.ExtractArchives
+
+	spool, size, err := spoolTemp(r)
+	if err != nil {
+		return err
+	}
+	defer removeTemp(spool)
+
+	ratio := opts.MaxRatio
+	if ratio <= 0 {
+		ratio = 100
+	}
+	x := &archiveExtraction{u: u, part: p, max: int64(ratio * float64(size))}
+	if x.max < 1024*1024 {
+		x.max = 1024 * 1024
+	}
+	if opts.MaxSize > 0 && opts.MaxSize < x.max {
+		x.max = opts.MaxSize
+	}
+
+	if fileType == "application/zip" {
+		return x.extractZip(spool, size)
+	}
+
+	gz, err := gzip.NewReader(spool)
+	if err != nil {
+		return &ArchiveError{Archive: p.fileName, Reason: err.Error()}
+	}
+
+	// tar archives have "ustar" at offset 257 of their first header
+	head := make([]byte, 262)
+	n, _ := io.ReadFull(gz, head)
+	if n < len(head) || !bytes.HasPrefix(head[257:], []byte("ustar")) {
+		if _, err := spool.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		return u.save(&uploadPart{field: p.field, fileName: p.fileName, header: p.header, reader: spool, size: size, fromArchive: true})
+	}
+
+	return x.extractTar(tar.NewReader(io.MultiReader(bytes.NewReader(head), gz)))
+}
+
+func (x *archiveExtraction) extractZip(r io.ReaderAt, size int64) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return &ArchiveError{Archive: x.part.fileName, Reason: err.Error()}
+	}
+
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		if f.Mode()&os.ModeSymlink != 0 {
+			return x.reject(f.Name, "symbolic links are not permitted")
+		}
+
+		err := func() error {
+			rc, err := f.Open()
+			if err != nil {
+				return x.reject(f.Name, err.Error())
+			}
+			defer rc.Close()
+
+			return x.saveEntry(f.Name, rc)
+		}()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (x *archiveExtraction) extractTar(tr *tar.Reader) error {
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return x.reject("", err.Error())
+		}
+
+		switch hdr.Typeflag {
+		// a PAX global header, which git archive writes first, only holds metadata
+		case tar.TypeDir, tar.TypeXGlobalHeader:
+			continue
+		case tar.TypeReg:
+			if err := x.saveEntry(hdr.Name, tr); err != nil {
+				return err
+			}
+		case tar.TypeSymlink, tar.TypeLink:
+			return x.reject(hdr.Name, "links are not permitted")
+		default:
+			return x.reject(hdr.Name, "only regular files are permitted")
+		}
+	}
+}
+
+// saveEntry checks the name of an entry and the limits of the archive, and saves the entry
+func (x *archiveExtraction) saveEntry(name string, r io.Reader) error {
+	if !safeArchivePath(name) {
+		return x.reject(name, "the entry path is not permitted")
+	}
+
+	maxEntries := x.u.t.ExtractArchives.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = 1000
+	}
+	x.entries++
+	if x.entries > maxEntries {
+		return x.reject("", fmt.Sprintf("it has more than %d entries", maxEntries))
+	}
+
+	err := x.u.save(&uploadPart{
+		field:       x.part.field,
+		fileName:    name,
+		reader:      &archiveEntryReader{x: x, r: r, name: name},
+		size:        -1,
+		fromArchive: true,
+	})
+	if err != nil {
+		return err
+	}
+
+	x.u.files[len(x.u.files)-1].Archive = x.part.fileName
+
+	return nil
+}
+
+func (x *archiveExtraction) reject(entry, reason string) error {
+	return &ArchiveError{Archive: x.part.fileName, Entry: entry, Reason: reason}
+}
+
+// safeArchivePath reports whether name stays inside the directory an archive is extracted to
+func safeArchivePath(name string) bool {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if name == "" || strings.HasPrefix(name, "/") || (len(name) > 1 && name[1] == ':') {
+		return false
+	}
+	for _, segment := range strings.Split(name, "/") {
+		if segment == ".." {
+			return false
+		}
+	}
+	return path.Clean(name) != "."
+}
+
+// archiveEntryReader counts the uncompressed bytes read from an archive, and stops as soon as
+// they pass the limit for the archive
+type archiveEntryReader struct {
+	x    *archiveExtraction
+	r    io.Reader
+	name string
+}
+
+func (a *archiveEntryReader) Read(p []byte) (int, error) {
+	n, err := a.r.Read(p)
+	a.x.read += int64(n)
+	if a.x.read > a.x.max {
+		return n, a.x.reject(a.name, "the uncompressed content is too large for the size of the archive")
+	}
+	return n, err
+}
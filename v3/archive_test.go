@@ -0,0 +1,184 @@
+package gotoolkit
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"testing"
+)
+
+type testEntry struct {
+	name    string
+	data    []byte
+	symlink bool
+	// global makes the entry a PAX global header, as git archive writes
+	global bool
+}
+
+func zipArchive(t *testing.T, entries ...testEntry) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+		if e.symlink {
+			hdr.SetMode(os.ModeSymlink | 0777)
+		}
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(e.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func tarGzArchive(t *testing.T, entries ...testEntry) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.data)), Typeflag: tar.TypeReg}
+		if e.symlink {
+			hdr = &tar.Header{Name: e.name, Linkname: "/etc/passwd", Typeflag: tar.TypeSymlink}
+		}
+		if e.global {
+			hdr = &tar.Header{Name: e.name, Typeflag: tar.TypeXGlobalHeader, PAXRecords: map[string]string{"comment": "0123abcd"}}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(e.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestTools_UploadFilesExtractArchives(t *testing.T) {
+	png := readTestFile(t, "./testdata/img.png")
+	jpg := readTestFile(t, "./testdata/pic.jpg")
+
+	archives := map[string][]byte{
+		"photos.zip":    zipArchive(t, testEntry{name: "listing/front.png", data: png}, testEntry{name: "back.jpg", data: jpg}),
+		"photos.tar.gz": tarGzArchive(t, testEntry{name: "pax_global_header", global: true}, testEntry{name: "listing/front.png", data: png}, testEntry{name: "back.jpg", data: jpg}),
+	}
+
+	for name, data := range archives {
+		store := &MemoryStorage{}
+		testTools := Tools{
+			Storage:          store,
+			AllowedFileTypes: []string{"image/png", "image/jpeg"},
+			ExtractArchives:  &ArchiveOptions{},
+		}
+
+		uploadedFiles, err := testTools.UploadFiles(multipartRequest(t, testPart{field: "photos", fileName: name, data: data}), "", false)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		if len(uploadedFiles) != 2 {
+			t.Fatalf("%s: expected 2 extracted files, got %d", name, len(uploadedFiles))
+		}
+		if uploadedFiles[0].OriginalFileName != "listing/front.png" || uploadedFiles[0].NewFileName != "front.png" || uploadedFiles[0].Archive != name {
+			t.Errorf("%s: unexpected first entry: %+v", name, uploadedFiles[0])
+		}
+		if uploadedFiles[1].ContentType != "image/jpeg" || uploadedFiles[1].FileSize != int64(len(jpg)) {
+			t.Errorf("%s: unexpected second entry: %+v", name, uploadedFiles[1])
+		}
+		if len(store.Keys()) != 2 {
+			t.Errorf("%s: expected only the entries to be stored, got %v", name, store.Keys())
+		}
+	}
+}
+
+var archiveRejectTests = []struct {
+	name    string
+	archive func(t *testing.T) []byte
+	options ArchiveOptions
+	typeErr bool
+}{
+	{name: "zip slip", archive: func(t *testing.T) []byte { return zipArchive(t, testEntry{name: "../../evil.txt", data: []byte("x")}) }},
+	{name: "absolute path", archive: func(t *testing.T) []byte {
+		return tarGzArchive(t, testEntry{name: "/etc/cron.d/evil", data: []byte("x")})
+	}},
+	{name: "zip symlink", archive: func(t *testing.T) []byte {
+		return zipArchive(t, testEntry{name: "link", data: []byte("/etc/passwd"), symlink: true})
+	}},
+	{name: "tar symlink", archive: func(t *testing.T) []byte { return tarGzArchive(t, testEntry{name: "link", symlink: true}) }},
+	{name: "too many entries", options: ArchiveOptions{MaxEntries: 2}, archive: func(t *testing.T) []byte {
+		return zipArchive(t, testEntry{name: "a.txt", data: []byte("a")}, testEntry{name: "b.txt", data: []byte("b")}, testEntry{name: "c.txt", data: []byte("c")})
+	}},
+	{name: "decompression bomb", archive: func(t *testing.T) []byte {
+		return zipArchive(t, testEntry{name: "aaaa.txt", data: bytes.Repeat([]byte("a"), 8*1024*1024)})
+	}},
+	{name: "max size", options: ArchiveOptions{MaxSize: 10}, archive: func(t *testing.T) []byte {
+		return tarGzArchive(t, testEntry{name: "a.txt", data: bytes.Repeat([]byte("abc"), 10)})
+	}},
+	{name: "entry type not permitted", typeErr: true, archive: func(t *testing.T) []byte {
+		return tarGzArchive(t, testEntry{name: "a.png", data: []byte("<html>not really</html>")})
+	}},
+}
+
+func TestTools_UploadFilesExtractArchivesRejects(t *testing.T) {
+	for _, e := range archiveRejectTests {
+		options := e.options
+		store := &MemoryStorage{}
+		testTools := Tools{
+			Storage:              store,
+			AllowedFileTypes:     []string{"text/plain; charset=utf-8", "image/png"},
+			ExtractArchives:      &options,
+			TransactionalUploads: true,
+		}
+
+		_, err := testTools.UploadFiles(multipartRequest(t, testPart{field: "file", fileName: "upload", data: e.archive(t)}), "")
+
+		var archiveErr *ArchiveError
+		switch {
+		case e.typeErr && !errors.Is(err, ErrFileTypeNotPermitted):
+			t.Errorf("%s: expected ErrFileTypeNotPermitted, got %v", e.name, err)
+		case !e.typeErr && !errors.As(err, &archiveErr):
+			t.Errorf("%s: expected *ArchiveError, got %v", e.name, err)
+		}
+
+		if len(store.Keys()) != 0 {
+			t.Errorf("%s: expected nothing to be stored, got %v", e.name, store.Keys())
+		}
+	}
+}
+
+func TestTools_UploadFilesPlainGzip(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, _ = gz.Write([]byte("just some compressed text"))
+	_ = gz.Close()
+
+	testTools := Tools{Storage: &MemoryStorage{}, ExtractArchives: &ArchiveOptions{}}
+
+	f, err := testTools.UploadOneFile(multipartRequest(t, testPart{field: "file", fileName: "notes.txt.gz", data: buf.Bytes()}), "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.NewFileName != "notes.txt.gz" || f.FileSize != int64(buf.Len()) || f.Archive != "" {
+		t.Errorf("expected the gzip file to be saved as it is, got %+v", f)
+	}
+}
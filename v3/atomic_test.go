@@ -0,0 +1,161 @@
+package gotoolkit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+type failingReader struct {
+	data string
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	if f.data == "" {
+		return 0, errors.New("connection reset")
+	}
+	n := copy(p, f.data)
+	f.data = f.data[n:]
+	return n, nil
+}
+
+func TestLocalStorage_PutIsAtomic(t *testing.T) {
+	dir := t.TempDir()
+	store := &LocalStorage{Root: dir}
+	ctx := context.Background()
+
+	if _, err := store.Put(ctx, "report.txt", strings.NewReader("original"), -1, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := store.Put(ctx, "report.txt", &failingReader{data: "half a new rep"}, -1, "")
+	if err == nil {
+		t.Fatal("expected the failing put to return an error")
+	}
+
+	rc, _, err := store.Get(ctx, "report.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(data) != "original" {
+		t.Errorf("expected the original file to be untouched, got %q", data)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("expected no temporary files to be left behind, found %d entries", len(entries))
+	}
+}
+
+var transactionalTests = []struct {
+	name          string
+	transactional bool
+	stream        bool
+	expectedLeft  int
+}{
+	{name: "form transactional", transactional: true, expectedLeft: 0},
+	{name: "stream transactional", transactional: true, stream: true, expectedLeft: 0},
+	{name: "stream not transactional", stream: true, expectedLeft: 2},
+}
+
+func TestTools_UploadFilesTransactional(t *testing.T) {
+	png := readTestFile(t, "./testdata/img.png")
+
+	for _, e := range transactionalTests {
+		dir := t.TempDir()
+		testTools := Tools{
+			AllowedFileTypes:     []string{"image/png"},
+			TransactionalUploads: e.transactional,
+			StreamUploads:        e.stream,
+		}
+
+		// the form field names keep the bad file last, however the parsed form is iterated
+		request := multipartRequest(t,
+			testPart{field: "a", fileName: "one.png", data: png},
+			testPart{field: "a", fileName: "two.png", data: png},
+			testPart{field: "a", fileName: "three.txt", data: []byte("not an image")},
+		)
+
+		uploadedFiles, err := testTools.UploadFiles(request, dir)
+		if !errors.Is(err, ErrFileTypeNotPermitted) {
+			t.Errorf("%s: expected ErrFileTypeNotPermitted, got %v", e.name, err)
+		}
+		if len(uploadedFiles) != e.expectedLeft {
+			t.Errorf("%s: expected the %d files left to be returned, got %d", e.name, e.expectedLeft, len(uploadedFiles))
+		}
+
+		entries, _ := os.ReadDir(dir)
+		if len(entries) != e.expectedLeft {
+			t.Errorf("%s: expected %d files left in the upload directory, found %d", e.name, e.expectedLeft, len(entries))
+		}
+	}
+}
+
+func TestTools_UploadFilesTransactionalKeepsExistingFile(t *testing.T) {
+	store := &MemoryStorage{}
+	if _, err := store.Put(context.Background(), "docs/report.txt", strings.NewReader("precious original"), -1, "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	testTools := Tools{Storage: store, TransactionalUploads: true, StreamUploads: true, FileNameCollision: CollisionOverwrite, AllowedFileTypes: []string{"text/plain; charset=utf-8"}}
+
+	request := multipartRequest(t,
+		testPart{field: "a", fileName: "report.txt", data: []byte("a new report")},
+		testPart{field: "b", fileName: "other.png", data: readTestFile(t, "./testdata/img.png")},
+	)
+	_, err := testTools.UploadFiles(request, "docs", false)
+	if !errors.Is(err, ErrFileExists) {
+		t.Errorf("expected ErrFileExists, got %v", err)
+	}
+
+	r, _, err := store.Get(context.Background(), "docs/report.txt")
+	if err != nil {
+		t.Fatalf("expected the existing file to be kept, got %v", err)
+	}
+	defer r.Close()
+	if data, _ := io.ReadAll(r); string(data) != "precious original" {
+		t.Errorf("expected the existing file to be unchanged, got %q", data)
+	}
+}
+
+func TestTools_UploadFilesTransactionalKeepsDuplicateThumbnails(t *testing.T) {
+	png := readTestFile(t, "./testdata/img.png")
+	store := &MemoryStorage{}
+	testTools := Tools{
+		Storage:              store,
+		ContentAddressed:     true,
+		TransactionalUploads: true,
+		AllowedFileTypes:     []string{"image/png"},
+		ImageProcessing:      &ImageOptions{Thumbnails: []Thumbnail{{Name: "small", Width: 16}}},
+	}
+
+	first, err := testTools.UploadOneFile(multipartRequest(t, testPart{field: "a", fileName: "one.png", data: png}), "img")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := store.Keys()
+
+	request := multipartRequest(t,
+		testPart{field: "a", fileName: "copy.png", data: png},
+		testPart{field: "b", fileName: "bad.txt", data: []byte("not an image")},
+	)
+	if _, err := testTools.UploadFiles(request, "img"); !errors.Is(err, ErrFileTypeNotPermitted) {
+		t.Fatalf("expected ErrFileTypeNotPermitted, got %v", err)
+	}
+
+	if len(store.Keys()) != len(stored) || len(stored) != 2 {
+		t.Errorf("expected the file and its thumbnail to be kept, got %v", store.Keys())
+	}
+
+	second, err := testTools.UploadOneFile(multipartRequest(t, testPart{field: "a", fileName: "copy.png", data: png}), "img")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !second.Duplicate || len(second.Variants) != 1 || second.Variants[0] != first.Variants[0] {
+		t.Errorf("expected the duplicate to report the existing thumbnail, got %+v", second.Variants)
+	}
+}
@@ -0,0 +1,271 @@
+package gotoolkit
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errUnsupportedBindType is returned when a struct field has a type values cannot be bound to
+var errUnsupportedBindType = errors.New("unsupported field type")
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+// ReadQuery binds the query string of r into data, which must be a pointer to a struct. Each
+// field is bound to the parameter named by its query tag, or failing that its json tag or its
+// name, and a default tag gives the value used when the parameter is missing or empty. Fields
+// may be strings, bools, ints, uints, floats, time.Time (RFC 3339 or a 2006-01-02 date),
+// time.Duration, or implement encoding.TextUnmarshaler, as well as pointers to these, which stay
+// nil when the parameter is missing, and slices of them, filled from repeated parameters or
+// comma separated lists. Embedded structs are bound as if their fields were part of data.
+// Problems are returned as a *JSONError, as ReadJSON does: unknown parameters are rejected unless
+// AllowUnknownFields is set, and data is validated when ValidateJSON is set.
+func (t *Tools) ReadQuery(r *http.Request, data any) error {
+	values, err := url.ParseQuery(r.URL.RawQuery)
+	if err != nil {
+		return &JSONError{Kind: JSONSyntax, Status: http.StatusBadRequest, Err: err, source: "query"}
+	}
+
+	return t.bind(values, data, "query")
+}
+
+// ReadForm binds the application/x-www-form-urlencoded body of r into data, following the rules
+// of ReadQuery but using form tags. The body may not be larger than MaxJSONSize.
+func (t *Tools) ReadForm(w http.ResponseWriter, r *http.Request, data any) error {
+	maxBytes := t.maxJSONSize()
+
+	contentType := r.Header.Get("Content-Type")
+	if mediaType, _, err := mime.ParseMediaType(contentType); err != nil || mediaType != "application/x-www-form-urlencoded" {
+		return &DecodeError{ContentType: contentType, Status: http.StatusUnsupportedMediaType, Err: errors.New("unsupported content type")}
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			return &JSONError{Kind: JSONTooLarge, Status: http.StatusRequestEntityTooLarge, Err: err}
+		}
+		return err
+	}
+
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return &JSONError{Kind: JSONSyntax, Status: http.StatusBadRequest, Err: err, source: "form"}
+	}
+
+	return t.bind(values, data, "form")
+}
+
+// bind sets the fields of the struct data points to from values, using the tag named source
+func (t *Tools) bind(values url.Values, data any, source string) error {
+	v := reflect.ValueOf(data)
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return &JSONError{
+			Kind:   JSONInvalidTarget,
+			Status: http.StatusInternalServerError,
+			Err:    fmt.Errorf("%s values can only be bound into a non-nil pointer to a struct", source),
+		}
+	}
+
+	known := map[string]bool{}
+	if err := bindStruct(v.Elem(), values, source, known); err != nil {
+		return err
+	}
+
+	if !t.AllowUnknownFields {
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			if !known[key] {
+				return &JSONError{Kind: JSONUnknownField, Field: key, Status: http.StatusBadRequest, source: source}
+			}
+		}
+	}
+
+	if t.ValidateJSON {
+		return t.Validate(data)
+	}
+
+	return nil
+}
+
+func bindStruct(v reflect.Value, values url.Values, source string, known map[string]bool) error {
+	typ := v.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+
+		name, tagged := f.Tag.Lookup(source)
+		if !tagged {
+			name, tagged = f.Tag.Lookup("json")
+		}
+		name, _, _ = strings.Cut(name, ",")
+		if name == "-" {
+			continue
+		}
+
+		if f.Anonymous && !tagged && f.Type.Kind() == reflect.Struct {
+			if err := bindStruct(v.Field(i), values, source, known); err != nil {
+				return err
+			}
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		known[name] = true
+
+		var raw []string
+		for _, value := range values[name] {
+			if value != "" {
+				raw = append(raw, value)
+			}
+		}
+		if len(raw) == 0 {
+			def, ok := f.Tag.Lookup("default")
+			if !ok {
+				continue
+			}
+			raw = []string{def}
+		}
+
+		if err := bindField(v.Field(i), raw); err != nil {
+			e := &JSONError{Kind: JSONTypeMismatch, Field: name, Status: http.StatusBadRequest, Err: err, source: source}
+			if errors.Is(err, errUnsupportedBindType) {
+				e.Kind, e.Status = JSONInvalidTarget, http.StatusInternalServerError
+			}
+			return e
+		}
+	}
+
+	return nil
+}
+
+// bindField sets v from raw. A slice gets every value, split at commas; anything else gets the
+// first.
+func bindField(v reflect.Value, raw []string) error {
+	if v.Kind() != reflect.Slice || textUnmarshaler(v) {
+		return bindValue(v, raw[0])
+	}
+
+	s := reflect.MakeSlice(v.Type(), 0, len(raw))
+	for _, value := range raw {
+		for _, part := range strings.Split(value, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := bindValue(elem, part); err != nil {
+				return err
+			}
+			s = reflect.Append(s, elem)
+		}
+	}
+	v.Set(s)
+
+	return nil
+}
+
+// textUnmarshaler reports whether v, which must be addressable, implements
+// encoding.TextUnmarshaler
+func textUnmarshaler(v reflect.Value) bool {
+	_, ok := v.Addr().Interface().(encoding.TextUnmarshaler)
+	return ok
+}
+
+func bindValue(v reflect.Value, s string) error {
+	if v.Kind() == reflect.Pointer {
+		p := reflect.New(v.Type().Elem())
+		if err := bindValue(p.Elem(), s); err != nil {
+			return err
+		}
+		v.Set(p)
+		return nil
+	}
+
+	switch v.Type() {
+	case timeType:
+		for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+			if tm, err := time.Parse(layout, s); err == nil {
+				v.Set(reflect.ValueOf(tm))
+				return nil
+			}
+		}
+		return errors.New("must be a date or an RFC 3339 time")
+	case durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return errors.New("must be a duration, such as 1h30m")
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		if err := u.UnmarshalText([]byte(s)); err != nil {
+			return fmt.Errorf("is not valid: %s", err)
+		}
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		switch strings.ToLower(s) {
+		case "on", "yes":
+			v.SetBool(true)
+		case "off", "no":
+			v.SetBool(false)
+		default:
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				return errors.New("must be true or false")
+			}
+			v.SetBool(b)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return errors.New("must be an integer")
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return errors.New("must be a non-negative integer")
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+			return errors.New("must be a number")
+		}
+		v.SetFloat(n)
+	default:
+		return fmt.Errorf("%w %s", errUnsupportedBindType, v.Type())
+	}
+
+	return nil
+}
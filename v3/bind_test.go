@@ -0,0 +1,189 @@
+package gotoolkit
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testPaging struct {
+	Page    int `query:"page" form:"page" default:"1"`
+	PerPage int `query:"per_page" form:"per_page" default:"20"`
+}
+
+type testSearch struct {
+	testPaging
+	Query    string        `query:"q" form:"q"`
+	MinPrice *float64      `query:"min_price" form:"min_price"`
+	Pets     bool          `json:"pets"`
+	Rooms    []int         `query:"rooms" form:"rooms"`
+	Tags     []string      `query:"tags" form:"tags" default:"any"`
+	From     time.Time     `query:"from" form:"from"`
+	Stay     time.Duration `query:"stay" form:"stay"`
+	Near     net.IP        `query:"near" form:"near"`
+	Limit    uint8         `query:"limit" form:"limit"`
+	Sort     string        `query:"-"`
+	internal string
+}
+
+func floatPointer(f float64) *float64 {
+	return &f
+}
+
+var bindTests = []struct {
+	name     string
+	query    string
+	expected testSearch
+	kind     JSONErrorKind
+	field    string
+	message  string
+}{
+	{
+		name:     "defaults",
+		query:    "",
+		expected: testSearch{testPaging: testPaging{Page: 1, PerPage: 20}, Tags: []string{"any"}},
+	},
+	{
+		name:  "all types",
+		query: "q=sea+view&page=3&min_price=99.5&pets=on&rooms=2&rooms=3,4&tags=a,b&from=2024-05-01&stay=72h&near=10.0.0.1&limit=255",
+		expected: testSearch{
+			testPaging: testPaging{Page: 3, PerPage: 20},
+			Query:      "sea view",
+			MinPrice:   floatPointer(99.5),
+			Pets:       true,
+			Rooms:      []int{2, 3, 4},
+			Tags:       []string{"a", "b"},
+			From:       time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+			Stay:       72 * time.Hour,
+			Near:       net.ParseIP("10.0.0.1"),
+			Limit:      255,
+		},
+	},
+	{
+		name:     "rfc 3339 time",
+		query:    "from=2024-05-01T10:00:00Z",
+		expected: testSearch{testPaging: testPaging{Page: 1, PerPage: 20}, Tags: []string{"any"}, From: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)},
+	},
+	{
+		name:     "empty values use defaults",
+		query:    "page=&tags=",
+		expected: testSearch{testPaging: testPaging{Page: 1, PerPage: 20}, Tags: []string{"any"}},
+	},
+	{name: "bad int", query: "page=two", kind: JSONTypeMismatch, field: "page", message: `query parameter "page" must be an integer`},
+	{name: "bad slice element", query: "rooms=1,x", kind: JSONTypeMismatch, field: "rooms"},
+	{name: "overflow", query: "limit=256", kind: JSONTypeMismatch, field: "limit"},
+	{name: "bad float", query: "min_price=NaN", kind: JSONTypeMismatch, field: "min_price"},
+	{name: "bad bool", query: "pets=maybe", kind: JSONTypeMismatch, field: "pets", message: `query parameter "pets" must be true or false`},
+	{name: "bad time", query: "from=yesterday", kind: JSONTypeMismatch, field: "from"},
+	{name: "bad text", query: "near=nowhere", kind: JSONTypeMismatch, field: "near"},
+	{name: "unknown", query: "q=a&color=red", kind: JSONUnknownField, field: "color", message: `query contains unknown parameter "color"`},
+	{name: "ignored field", query: "Sort=price", kind: JSONUnknownField, field: "Sort"},
+	{name: "malformed", query: "q=%zz", kind: JSONSyntax},
+}
+
+func TestTools_ReadQuery(t *testing.T) {
+	var testTools Tools
+
+	for _, e := range bindTests {
+		req := httptest.NewRequest("GET", "/?"+e.query, nil)
+
+		var got testSearch
+		err := testTools.ReadQuery(req, &got)
+
+		if e.kind == 0 {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", e.name, err)
+			} else if !reflect.DeepEqual(got, e.expected) {
+				t.Errorf("%s: expected %+v, got %+v", e.name, e.expected, got)
+			}
+			continue
+		}
+
+		var jsonErr *JSONError
+		if !errors.As(err, &jsonErr) || jsonErr.Kind != e.kind || jsonErr.Field != e.field || jsonErr.Status != http.StatusBadRequest {
+			t.Errorf("%s: expected a %s error for %q, got %v", e.name, e.kind, e.field, err)
+			continue
+		}
+		if e.message != "" && err.Error() != e.message {
+			t.Errorf("%s: expected message %q, got %q", e.name, e.message, err.Error())
+		}
+	}
+}
+
+func TestTools_ReadQueryOptions(t *testing.T) {
+	testTools := Tools{AllowUnknownFields: true, ValidateJSON: true}
+
+	type search struct {
+		Page int    `query:"page" validate:"min=1"`
+		Sort string `query:"sort" validate:"oneof=price date"`
+	}
+
+	var got search
+	req := httptest.NewRequest("GET", "/?page=2&sort=price&utm_source=mail", nil)
+	if err := testTools.ReadQuery(req, &got); err != nil || got.Page != 2 || got.Sort != "price" {
+		t.Errorf("expected page 2 sorted by price, got %+v %v", got, err)
+	}
+
+	req = httptest.NewRequest("GET", "/?page=0&sort=price", nil)
+	var validationErr *ValidationError
+	if err := testTools.ReadQuery(req, &got); !errors.As(err, &validationErr) {
+		t.Errorf("expected a validation error, got %v", err)
+	}
+
+	var jsonErr *JSONError
+	if err := testTools.ReadQuery(req, got); !errors.As(err, &jsonErr) || jsonErr.Kind != JSONInvalidTarget {
+		t.Errorf("expected an invalid target error, got %v", err)
+	}
+
+	var unsupported struct {
+		Filter map[string]string `query:"filter"`
+	}
+	req = httptest.NewRequest("GET", "/?filter=x", nil)
+	if err := testTools.ReadQuery(req, &unsupported); !errors.As(err, &jsonErr) || jsonErr.Status != http.StatusInternalServerError {
+		t.Errorf("expected an internal error for an unsupported field type, got %v", err)
+	}
+}
+
+func TestTools_ReadForm(t *testing.T) {
+	testTools := Tools{MaxJSONSize: 64}
+
+	var tests = []struct {
+		name        string
+		contentType string
+		body        string
+		status      int
+	}{
+		{name: "valid", contentType: "application/x-www-form-urlencoded; charset=utf-8", body: "q=cabin&rooms=1&rooms=2&pets=true"},
+		{name: "bad value", contentType: "application/x-www-form-urlencoded", body: "rooms=many", status: http.StatusBadRequest},
+		{name: "unknown", contentType: "application/x-www-form-urlencoded", body: "q=cabin&x=1", status: http.StatusBadRequest},
+		{name: "too large", contentType: "application/x-www-form-urlencoded", body: "q=" + strings.Repeat("a", 100), status: http.StatusRequestEntityTooLarge},
+		{name: "wrong content type", contentType: "application/json", body: `{"q": "cabin"}`, status: http.StatusUnsupportedMediaType},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("POST", "/?page=5", strings.NewReader(e.body))
+		req.Header.Set("Content-Type", e.contentType)
+
+		var got testSearch
+		err := testTools.ReadForm(httptest.NewRecorder(), req, &got)
+
+		if e.status == 0 {
+			expected := testSearch{testPaging: testPaging{Page: 1, PerPage: 20}, Query: "cabin", Rooms: []int{1, 2}, Pets: true, Tags: []string{"any"}}
+			if err != nil || !reflect.DeepEqual(got, expected) {
+				t.Errorf("%s: expected %+v, got %+v %v", e.name, expected, got, err)
+			}
+			continue
+		}
+
+		rr := httptest.NewRecorder()
+		_ = testTools.ErrorJSON(rr, err)
+		if err == nil || rr.Code != e.status {
+			t.Errorf("%s: expected status %d, got %d (%v)", e.name, e.status, rr.Code, err)
+		}
+	}
+}
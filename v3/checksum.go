@@ -0,0 +1,83 @@
+package gotoolkit
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/textproto"
+	"strings"
+)
+
+// ChecksumMismatchError is returned when an uploaded file does not match the checksum the client
+// sent with it
+type ChecksumMismatchError struct {
+	FileName  string
+	Algorithm string
+	Expected  string
+	Actual    string
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("the uploaded file %s does not match its %s checksum: expected %s, got %s",
+		e.FileName, e.Algorithm, e.Expected, e.Actual)
+}
+
+// uploadHashes computes the checksums of a file as it is written to it
+type uploadHashes struct {
+	sha256 hash.Hash
+	md5    hash.Hash
+	w      io.Writer
+}
+
+func newUploadHashes(withMD5 bool) *uploadHashes {
+	h := &uploadHashes{sha256: sha256.New()}
+	h.w = h.sha256
+	if withMD5 {
+		h.md5 = md5.New()
+		h.w = io.MultiWriter(h.sha256, h.md5)
+	}
+	return h
+}
+
+func (h *uploadHashes) Write(p []byte) (int, error) {
+	return h.w.Write(p)
+}
+
+// sums returns the hex encoded SHA-256, and MD5 if it was requested
+func (h *uploadHashes) sums() (string, string) {
+	sha := hex.EncodeToString(h.sha256.Sum(nil))
+	if h.md5 == nil {
+		return sha, ""
+	}
+	return sha, hex.EncodeToString(h.md5.Sum(nil))
+}
+
+// verifyChecksums compares the checksums of an uploaded file with those sent by the client in the
+// headers of its part: X-Checksum-SHA256 as hex, and Content-MD5 as base64 (RFC 1864) or hex.
+// The MD5 sum must have been computed whenever a Content-MD5 header is present.
+func verifyChecksums(header textproto.MIMEHeader, fileName string, h *uploadHashes) error {
+	if header == nil {
+		return nil
+	}
+
+	if expected := strings.TrimSpace(header.Get("X-Checksum-Sha256")); expected != "" {
+		actual := hex.EncodeToString(h.sha256.Sum(nil))
+		if !strings.EqualFold(expected, actual) {
+			return &ChecksumMismatchError{FileName: fileName, Algorithm: "SHA-256", Expected: expected, Actual: actual}
+		}
+	}
+
+	if expected := strings.TrimSpace(header.Get("Content-Md5")); expected != "" {
+		sum := h.md5.Sum(nil)
+		actual := hex.EncodeToString(sum)
+		if !strings.EqualFold(expected, actual) && expected != base64.StdEncoding.EncodeToString(sum) {
+			return &ChecksumMismatchError{FileName: fileName, Algorithm: "MD5", Expected: expected, Actual: actual}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,138 @@
+package gotoolkit
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestTools_UploadFilesChecksums(t *testing.T) {
+	data := readTestFile(t, "./testdata/pic.jpg")
+	shaSum := sha256.Sum256(data)
+	md5Sum := md5.Sum(data)
+
+	testTools := Tools{Storage: &MemoryStorage{}, StreamUploads: true, ChecksumMD5: true}
+
+	uploadedFiles, err := testTools.UploadFiles(multipartRequest(t, testPart{field: "file", fileName: "pic.jpg", data: data}), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if uploadedFiles[0].SHA256 != hex.EncodeToString(shaSum[:]) {
+		t.Errorf("wrong SHA-256: %s", uploadedFiles[0].SHA256)
+	}
+	if uploadedFiles[0].MD5 != hex.EncodeToString(md5Sum[:]) {
+		t.Errorf("wrong MD5: %s", uploadedFiles[0].MD5)
+	}
+}
+
+func TestTools_UploadFilesContentAddressed(t *testing.T) {
+	data := readTestFile(t, "./testdata/pic.jpg")
+	store := &MemoryStorage{}
+	testTools := Tools{Storage: store, ContentAddressed: true}
+
+	first, err := testTools.UploadOneFile(multipartRequest(t, testPart{field: "file", fileName: "brochure.JPG", data: data}), "brochures")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := testTools.UploadOneFile(multipartRequest(t, testPart{field: "file", fileName: "copy.jpg", data: data}), "brochures")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first.Duplicate || !second.Duplicate {
+		t.Errorf("expected only the second upload to be a duplicate: %v %v", first.Duplicate, second.Duplicate)
+	}
+	if first.Key != second.Key || first.Key != "brochures/"+first.SHA256+".jpg" {
+		t.Errorf("expected both uploads to share the content addressed key, got %s and %s", first.Key, second.Key)
+	}
+	if second.OriginalFileName != "copy.jpg" || second.FileSize != int64(len(data)) {
+		t.Errorf("unexpected duplicate file: %+v", second)
+	}
+	if len(store.Keys()) != 1 {
+		t.Errorf("expected the file to be stored once, got %v", store.Keys())
+	}
+}
+
+var expectedChecksumTests = []struct {
+	name             string
+	header           map[string]string
+	contentAddressed bool
+	errorExpected    bool
+}{
+	{name: "sha256 matches", header: map[string]string{"X-Checksum-SHA256": "sha"}},
+	{name: "md5 base64 matches", header: map[string]string{"Content-MD5": "md5"}},
+	{name: "sha256 mismatch", header: map[string]string{"X-Checksum-SHA256": "00"}, errorExpected: true},
+	{name: "md5 mismatch", header: map[string]string{"Content-MD5": "AAAA"}, errorExpected: true},
+	{name: "content addressed mismatch", header: map[string]string{"X-Checksum-SHA256": "00"}, contentAddressed: true, errorExpected: true},
+}
+
+func TestTools_UploadFilesExpectedChecksum(t *testing.T) {
+	data := []byte("a brochure")
+	shaSum := sha256.Sum256(data)
+	md5Sum := md5.Sum(data)
+
+	for _, e := range expectedChecksumTests {
+		header := make(map[string]string)
+		for k, v := range e.header {
+			switch v {
+			case "sha":
+				v = hex.EncodeToString(shaSum[:])
+			case "md5":
+				v = base64.StdEncoding.EncodeToString(md5Sum[:])
+			}
+			header[k] = v
+		}
+
+		store := &MemoryStorage{}
+		testTools := Tools{Storage: store, StreamUploads: true, ContentAddressed: e.contentAddressed}
+
+		_, err := testTools.UploadFiles(multipartRequest(t, testPart{field: "file", fileName: "a.txt", data: data, header: header}), "")
+
+		if !e.errorExpected {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %s", e.name, err)
+			}
+			continue
+		}
+
+		var mismatch *ChecksumMismatchError
+		if !errors.As(err, &mismatch) {
+			t.Errorf("%s: expected *ChecksumMismatchError, got %v", e.name, err)
+		}
+		if len(store.Keys()) != 0 {
+			t.Errorf("%s: expected nothing to be stored, got %v", e.name, store.Keys())
+		}
+	}
+}
+
+func TestTools_UploadFilesChecksumMismatchKeepsExistingFile(t *testing.T) {
+	store := &MemoryStorage{}
+	if _, err := store.Put(context.Background(), "a.txt", strings.NewReader("the original"), -1, "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	testTools := Tools{Storage: store, StreamUploads: true}
+
+	header := map[string]string{"X-Checksum-SHA256": "00"}
+	_, err := testTools.UploadFiles(multipartRequest(t, testPart{field: "file", fileName: "a.txt", data: []byte("a replacement"), header: header}), "", false)
+
+	var mismatch *ChecksumMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("expected *ChecksumMismatchError, got %v", err)
+	}
+
+	r, _, err := store.Get(context.Background(), "a.txt")
+	if err != nil {
+		t.Fatalf("expected the existing file to be kept, got %v", err)
+	}
+	defer r.Close()
+	if data, _ := io.ReadAll(r); string(data) != "the original" {
+		t.Errorf("expected the existing file to be unchanged, got %q", data)
+	}
+}
@@ -0,0 +1,155 @@
+package gotoolkit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// ErrRemoteTooLarge is the Err of a *RemoteError for a response body larger than
+// Tools.MaxRemoteJSONSize
+var ErrRemoteTooLarge = errors.New("response body is too large")
+
+// RemoteError is returned by DoJSON when the remote service answers with a status outside 2xx,
+// or with a body that cannot be decoded, in which case Err says why. Body holds the response
+// body, up to the size limit. An RFC 7807 problem document is decoded into Problem, and Message
+// is taken from it, or from the message of a JSONResponse. ErrorJSON sends a RemoteError as
+// 502 Bad Gateway.
+type RemoteError struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	Problem    *Problem
+	Message    string
+	Err        error
+}
+
+func (e *RemoteError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("remote service sent an invalid response (status %d): %s", e.StatusCode, e.Err)
+	}
+	if e.Message != "" {
+		return fmt.Sprintf("remote service returned %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+	}
+	return fmt.Sprintf("remote service returned %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+func (e *RemoteError) Unwrap() error {
+	return e.Err
+}
+
+// GetJSON calls DoJSON to GET uri
+func GetJSON[T any](ctx context.Context, t *Tools, uri string, headers ...http.Header) (T, *http.Response, error) {
+	return DoJSON[T](ctx, t, http.MethodGet, uri, nil, headers...)
+}
+
+// DoJSON sends a request to uri with t.HTTPClient, or http.DefaultClient, and decodes a 2xx
+// response into a T. Unless data is nil, it is sent as the JSON body. Any other status returns a
+// *RemoteError describing it. Response bodies are limited to t.MaxRemoteJSONSize, or the same
+// size as request bodies. The response is returned for its status and headers, with the body
+// already read and closed; a response without a body, such as 204 No Content, leaves T as its
+// zero value.
+func DoJSON[T any](ctx context.Context, t *Tools, method, uri string, data any, headers ...http.Header) (T, *http.Response, error) {
+	var result T
+
+	var body io.Reader
+	if data != nil {
+		jsonData, err := json.Marshal(data)
+		if err != nil {
+			return result, nil, err
+		}
+		body = bytes.NewReader(jsonData)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, uri, body)
+	if err != nil {
+		return result, nil, err
+	}
+	if len(headers) > 0 {
+		for key, value := range headers[0] {
+			request.Header[key] = value
+		}
+	}
+	if data != nil && request.Header.Get("Content-Type") == "" {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if request.Header.Get("Accept") == "" {
+		request.Header.Set("Accept", "application/json, application/problem+json")
+	}
+
+	client := t.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return result, nil, err
+	}
+	defer response.Body.Close()
+
+	maxBytes := t.maxJSONSize()
+	if t.MaxRemoteJSONSize != 0 {
+		maxBytes = t.MaxRemoteJSONSize
+	}
+
+	out, err := io.ReadAll(io.LimitReader(response.Body, int64(maxBytes)+1))
+	if err != nil {
+		return result, response, err
+	}
+
+	remoteErr := &RemoteError{StatusCode: response.StatusCode, Header: response.Header, Body: out}
+	if len(out) > maxBytes {
+		remoteErr.Body = out[:maxBytes]
+		remoteErr.Err = ErrRemoteTooLarge
+		return result, response, remoteErr
+	}
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		remoteErr.readErrorBody()
+		return result, response, remoteErr
+	}
+
+	if len(bytes.TrimSpace(out)) == 0 {
+		return result, response, nil
+	}
+	if err := json.Unmarshal(out, &result); err != nil {
+		remoteErr.Err = err
+		return result, response, remoteErr
+	}
+
+	return result, response, nil
+}
+
+// readErrorBody fills in Problem and Message from an error response, if its body is a problem
+// document, a JSONResponse or plain text
+func (e *RemoteError) readErrorBody() {
+	mediaType, _, _ := mime.ParseMediaType(e.Header.Get("Content-Type"))
+
+	switch {
+	case mediaType == "application/problem+json":
+		var p Problem
+		if json.Unmarshal(e.Body, &p) == nil {
+			e.Problem = &p
+			e.Message = p.Error()
+		}
+
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		var payload JSONResponse
+		if json.Unmarshal(e.Body, &payload) == nil {
+			e.Message = payload.Message
+		}
+
+	case mediaType == "text/plain":
+		e.Message = strings.TrimSpace(string(e.Body))
+		if len(e.Message) > 256 {
+			e.Message = strings.ToValidUTF8(e.Message[:256], "") + "..."
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package gotoolkit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDoJSON(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Token") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Method {
+		case "GET":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = io.WriteString(w, `{"name": "Sea view", "rooms": 3}`)
+		case "POST":
+			if r.Header.Get("Content-Type") != "application/json" {
+				w.WriteHeader(http.StatusUnsupportedMediaType)
+				return
+			}
+			var p testPayload
+			_ = json.NewDecoder(r.Body).Decode(&p)
+			p.Rooms++
+			w.Header().Set("Location", "/listings/1")
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(p)
+		case "DELETE":
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer srv.Close()
+
+	var testTools Tools
+	ctx := context.Background()
+	headers := http.Header{"X-Token": {"secret"}}
+
+	got, _, err := GetJSON[testPayload](ctx, &testTools, srv.URL, headers)
+	if err != nil || got.Name != "Sea view" || got.Rooms != 3 {
+		t.Errorf("get: expected the payload, got %+v %v", got, err)
+	}
+
+	got, resp, err := DoJSON[testPayload](ctx, &testTools, "POST", srv.URL, testPayload{Name: "Lake", Rooms: 1}, headers)
+	if err != nil || got.Rooms != 2 || resp.StatusCode != http.StatusCreated || resp.Header.Get("Location") != "/listings/1" {
+		t.Errorf("post: expected the created payload, got %+v %v", got, err)
+	}
+
+	got, resp, err = DoJSON[testPayload](ctx, &testTools, "DELETE", srv.URL, nil, headers)
+	if err != nil || got != (testPayload{}) || resp.StatusCode != http.StatusNoContent {
+		t.Errorf("delete: expected no content, got %+v %v", got, err)
+	}
+
+	_, _, err = GetJSON[testPayload](ctx, &testTools, srv.URL)
+	var remoteErr *RemoteError
+	if !errors.As(err, &remoteErr) || remoteErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected a 401 RemoteError, got %v", err)
+	}
+}
+
+var remoteErrorTests = []struct {
+	name        string
+	status      int
+	contentType string
+	body        string
+	message     string
+	problem     bool
+	invalid     bool
+	err         error
+}{
+	{name: "problem", status: 404, contentType: "application/problem+json", body: `{"type": "about:blank", "title": "Not Found", "status": 404, "detail": "no such listing", "listing": 7}`, message: "Not Found: no such listing", problem: true},
+	{name: "json response", status: 400, contentType: "application/json; charset=utf-8", body: `{"error": true, "message": "bad request"}`, message: "bad request"},
+	{name: "plain text", status: 503, contentType: "text/plain", body: "down for maintenance\n", message: "down for maintenance"},
+	{name: "html", status: 500, contentType: "text/html", body: "<h1>oops</h1>"},
+	{name: "invalid success body", status: 200, contentType: "application/json", body: `{"name": `, invalid: true},
+	{name: "too large", status: 200, contentType: "application/json", body: `{"name": "` + strings.Repeat("a", 300) + `"}`, invalid: true, err: ErrRemoteTooLarge},
+	{name: "too large error", status: 500, contentType: "text/plain", body: strings.Repeat("a", 300), invalid: true, err: ErrRemoteTooLarge},
+}
+
+func TestDoJSON_RemoteError(t *testing.T) {
+	for _, e := range remoteErrorTests {
+		client := NewTestClient(func(req *http.Request) *http.Response {
+			return &http.Response{
+				StatusCode: e.status,
+				Body:       io.NopCloser(strings.NewReader(e.body)),
+				Header:     http.Header{"Content-Type": {e.contentType}},
+			}
+		})
+		testTools := Tools{HTTPClient: client, MaxRemoteJSONSize: 256}
+
+		_, _, err := GetJSON[testPayload](context.Background(), &testTools, "http://example.com/listings/7")
+
+		var remoteErr *RemoteError
+		if !errors.As(err, &remoteErr) {
+			t.Errorf("%s: expected a RemoteError, got %v", e.name, err)
+			continue
+		}
+		if remoteErr.StatusCode != e.status || remoteErr.Message != e.message || (remoteErr.Problem != nil) != e.problem {
+			t.Errorf("%s: unexpected error %+v", e.name, remoteErr)
+		}
+		if (remoteErr.Err != nil) != e.invalid || (e.err != nil && !errors.Is(err, e.err)) {
+			t.Errorf("%s: unexpected cause %v", e.name, remoteErr.Err)
+		}
+		if len(remoteErr.Body) > 256 {
+			t.Errorf("%s: kept %d bytes of the body", e.name, len(remoteErr.Body))
+		}
+
+		rr := httptest.NewRecorder()
+		_ = testTools.ErrorJSON(rr, err)
+		if rr.Code != http.StatusBadGateway {
+			t.Errorf("%s: expected ErrorJSON to send 502, got %d", e.name, rr.Code)
+		}
+	}
+
+	var problemErr *RemoteError
+	client := NewTestClient(func(req *http.Request) *http.Response {
+		return &http.Response{
+			StatusCode: 404,
+			Body:       io.NopCloser(strings.NewReader(remoteErrorTests[0].body)),
+			Header:     http.Header{"Content-Type": {remoteErrorTests[0].contentType}},
+		}
+	})
+	_, _, err := GetJSON[testPayload](context.Background(), &Tools{HTTPClient: client}, "http://example.com/listings/7")
+	if !errors.As(err, &problemErr) || problemErr.Problem.Extensions["listing"] != float64(7) {
+		t.Errorf("expected the problem extensions, got %v", err)
+	}
+}
+
+func TestDoJSON_Context(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	var testTools Tools
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, _, err := GetJSON[testPayload](ctx, &testTools, srv.URL)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
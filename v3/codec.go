@@ -0,0 +1,166 @@
+package gotoolkit
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec encodes and decodes one body format for Write and Read. Decode is given the whole body,
+// which must hold exactly one value; when strict is set, it should also reject data that does not
+// match a field of v.
+type Codec interface {
+	Encode(w io.Writer, v any) error
+	Decode(data []byte, v any, strict bool) error
+}
+
+// builtinCodecs are the formats Write and Read support without any configuration, in order of
+// preference
+var builtinCodecs = []struct {
+	mediaType string
+	codec     Codec
+}{
+	{"application/json", jsonCodec{}},
+	{"application/xml", xmlCodec{}},
+	{"text/xml", xmlCodec{}},
+	{"application/msgpack", msgpackCodec{}},
+	{"application/x-msgpack", msgpackCodec{}},
+	{"application/vnd.msgpack", msgpackCodec{}},
+	{"application/cbor", cborCodec{}},
+}
+
+// DecodeError is returned by Read when a body that is not JSON cannot be decoded. Status is the
+// HTTP status code best suited to a response, which ErrorJSON uses when no status is given to it.
+type DecodeError struct {
+	ContentType string
+	Status      int
+	Err         error
+}
+
+func (e *DecodeError) Error() string {
+	var maxBytesError *http.MaxBytesError
+	switch {
+	case e.Status == http.StatusUnsupportedMediaType:
+		return fmt.Sprintf("body has unsupported content type %q", e.ContentType)
+	case errors.As(e.Err, &maxBytesError):
+		return fmt.Sprintf("body must not be larger than %d bytes", maxBytesError.Limit)
+	default:
+		return fmt.Sprintf("body contains invalid %s: %s", e.ContentType, e.Err)
+	}
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// errTrailingData is returned by the built-in codecs when a body holds more than one value
+var errTrailingData = errors.New("body must contain a single value")
+
+type jsonCodec struct{}
+
+func (jsonCodec) Encode(w io.Writer, v any) error {
+	out, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+func (jsonCodec) Decode(data []byte, v any, strict bool) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if strict {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if dec.More() {
+		return errTrailingData
+	}
+	return nil
+}
+
+// xmlCodec uses encoding/xml, which has no way to reject unknown elements, so strict is ignored
+type xmlCodec struct{}
+
+func (xmlCodec) Encode(w io.Writer, v any) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	return xml.NewEncoder(w).Encode(v)
+}
+
+func (xmlCodec) Decode(data []byte, v any, strict bool) error {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			return errTrailingData
+		case xml.CharData:
+			if len(bytes.TrimSpace(tok)) > 0 {
+				return errTrailingData
+			}
+		}
+	}
+}
+
+// msgpackCodec uses the json tags of structs, so the same types can be used for every format
+type msgpackCodec struct{}
+
+func (msgpackCodec) Encode(w io.Writer, v any) error {
+	enc := msgpack.NewEncoder(w)
+	enc.SetCustomStructTag("json")
+	return enc.Encode(v)
+}
+
+func (msgpackCodec) Decode(data []byte, v any, strict bool) error {
+	r := bytes.NewReader(data)
+	dec := msgpack.NewDecoder(r)
+	dec.SetCustomStructTag("json")
+	dec.DisallowUnknownFields(strict)
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if r.Len() > 0 {
+		return errTrailingData
+	}
+	return nil
+}
+
+// cborCodec falls back to the json tags of structs when they have no cbor tags
+type cborCodec struct{}
+
+func (cborCodec) Encode(w io.Writer, v any) error {
+	return cbor.NewEncoder(w).Encode(v)
+}
+
+func (cborCodec) Decode(data []byte, v any, strict bool) error {
+	opts := cbor.DecOptions{}
+	if strict {
+		opts.ExtraReturnErrors = cbor.ExtraDecErrorUnknownField
+	}
+	dm, err := opts.DecMode()
+	if err != nil {
+		return err
+	}
+	return dm.Unmarshal(data, v)
+}
@@ -0,0 +1,305 @@
+package gotoolkit
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// contentEncodings are the encodings OptimizeResponses can compress with, in order of preference
+var contentEncodings = []string{"br", "gzip", "deflate"}
+
+// send writes body to the client with status and contentType, after any extra headers. When
+// t.ETags is set, a 200 OK response is given a strong ETag computed from the body, unless the
+// headers already hold one. It is shared by the response writers so that OptimizeResponses has a
+// Content-Length and ETag to work with.
+func (t *Tools) send(w http.ResponseWriter, status int, contentType string, body []byte, headers ...http.Header) error {
+	if len(headers) > 0 {
+		for key, value := range headers[0] {
+			w.Header()[key] = value
+		}
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	if t.ETags && status == http.StatusOK && w.Header().Get("ETag") == "" {
+		w.Header().Set("ETag", strongETag(body))
+	}
+	w.WriteHeader(status)
+	_, err := w.Write(body)
+
+	return err
+}
+
+// strongETag returns a strong entity tag for body
+func strongETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + base64.RawURLEncoding.EncodeToString(sum[:]) + `"`
+}
+
+// OptimizeResponses is middleware that compresses the responses of next with brotli, gzip or
+// deflate, as negotiated with the Accept-Encoding header, when they are text, JSON or XML of at
+// least t.CompressMinSize bytes. It also answers a GET or HEAD request with 304 Not Modified when
+// its If-None-Match header matches the ETag of a 200 OK response, such as those sent by WriteJSON
+// when t.ETags is set. A compressed response has the encoding added to its ETag, since it is a
+// different representation.
+func (t *Tools) OptimizeResponses(next http.Handler) http.Handler {
+	minSize := 1024
+	if t.CompressMinSize != 0 {
+		minSize = t.CompressMinSize
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		o := &optimizedWriter{ResponseWriter: w, r: r, minSize: minSize}
+		defer o.close()
+		next.ServeHTTP(o, r)
+	})
+}
+
+// encoder is a compressing writer from compress/gzip, compress/zlib or brotli
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// optimizedWriter holds back the status and the start of the body until it knows whether the
+// response is large enough to compress, from its Content-Length header or from the body itself
+type optimizedWriter struct {
+	http.ResponseWriter
+	r           *http.Request
+	minSize     int
+	status      int
+	buf         []byte
+	decided     bool
+	notModified bool
+	enc         encoder
+}
+
+func (o *optimizedWriter) WriteHeader(status int) {
+	if o.status != 0 {
+		return
+	}
+	if status < 200 {
+		o.ResponseWriter.WriteHeader(status)
+		return
+	}
+	o.status = status
+
+	if n, err := strconv.Atoi(o.Header().Get("Content-Length")); err == nil {
+		o.decide(n >= o.minSize)
+	}
+}
+
+func (o *optimizedWriter) Write(p []byte) (int, error) {
+	if o.status == 0 {
+		o.WriteHeader(http.StatusOK)
+	}
+	if o.decided {
+		return o.write(p)
+	}
+
+	o.buf = append(o.buf, p...)
+	if len(o.buf) >= o.minSize {
+		if err := o.release(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush sends what has been written so far, compressing it if the response is compressible
+// whatever its size, since a flushed response is usually a stream
+func (o *optimizedWriter) Flush() {
+	if o.status == 0 {
+		o.WriteHeader(http.StatusOK)
+	}
+	if !o.decided {
+		if err := o.release(true); err != nil {
+			return
+		}
+	}
+	if o.enc != nil {
+		if err := o.enc.Flush(); err != nil {
+			return
+		}
+	}
+	if f, ok := o.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying ResponseWriter, for http.ResponseController
+func (o *optimizedWriter) Unwrap() http.ResponseWriter {
+	return o.ResponseWriter
+}
+
+// release decides how to send the response and writes out the buffered start of the body
+func (o *optimizedWriter) release(compress bool) error {
+	o.decide(compress)
+	buf := o.buf
+	o.buf = nil
+	_, err := o.write(buf)
+	return err
+}
+
+func (o *optimizedWriter) write(p []byte) (int, error) {
+	switch {
+	case o.notModified:
+		return len(p), nil
+	case o.enc != nil:
+		return o.enc.Write(p)
+	default:
+		return o.ResponseWriter.Write(p)
+	}
+}
+
+// decide sends the status and headers, choosing an encoding if compress is set and the response
+// can be compressed, or sends 304 Not Modified if the request's If-None-Match matches
+func (o *optimizedWriter) decide(compress bool) {
+	o.decided = true
+	h := o.Header()
+
+	if h.Get("Content-Type") == "" && len(o.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(o.buf))
+	}
+
+	eligible := h.Get("Content-Encoding") == "" && compressible(h.Get("Content-Type")) &&
+		o.status != http.StatusNoContent && o.status != http.StatusPartialContent && o.status != http.StatusNotModified
+
+	encoding := ""
+	if eligible {
+		h.Add("Vary", "Accept-Encoding")
+		if compress {
+			encoding = negotiateEncoding(o.r.Header.Get("Accept-Encoding"))
+		}
+	}
+
+	if encoding != "" {
+		h.Set("Content-Encoding", encoding)
+		h.Del("Content-Length")
+		h.Del("Accept-Ranges")
+		if etag := h.Get("ETag"); strings.HasSuffix(etag, `"`) {
+			h.Set("ETag", etag[:len(etag)-1]+"-"+encoding+`"`)
+		}
+	}
+
+	if o.status == http.StatusOK && (o.r.Method == http.MethodGet || o.r.Method == http.MethodHead) &&
+		etagMatches(o.r.Header.Get("If-None-Match"), h.Get("ETag")) {
+		o.notModified = true
+		h.Del("Content-Type")
+		h.Del("Content-Length")
+		h.Del("Content-Encoding")
+		o.ResponseWriter.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	o.ResponseWriter.WriteHeader(o.status)
+
+	switch encoding {
+	case "br":
+		o.enc = brotli.NewWriter(o.ResponseWriter)
+	case "gzip":
+		o.enc = gzip.NewWriter(o.ResponseWriter)
+	case "deflate":
+		o.enc = zlib.NewWriter(o.ResponseWriter)
+	}
+}
+
+// close sends whatever is still held back once the handler has returned
+func (o *optimizedWriter) close() {
+	if o.status == 0 {
+		return
+	}
+	if !o.decided {
+		if err := o.release(false); err != nil {
+			return
+		}
+	}
+	if o.enc != nil {
+		_ = o.enc.Close()
+	}
+}
+
+// compressible reports whether a response of contentType is worth compressing
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	switch {
+	case mediaType == "text/event-stream":
+		return false
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+json"), strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+
+	switch mediaType {
+	case "application/json", "application/xml", "application/javascript", "application/x-ndjson", "image/svg+xml":
+		return true
+	}
+	return false
+}
+
+// negotiateEncoding returns the encoding from contentEncodings that best matches the
+// Accept-Encoding header acceptEncoding, or "" if the response should not be compressed. Ties in
+// q-value go to the order of contentEncodings.
+func negotiateEncoding(acceptEncoding string) string {
+	qs := map[string]float64{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+
+		q := 1.0
+		if name, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(name) == "q" {
+			var err error
+			if q, err = strconv.ParseFloat(strings.TrimSpace(value), 64); err != nil || q < 0 || q > 1 {
+				continue
+			}
+		}
+		qs[coding] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, coding := range contentEncodings {
+		q, ok := qs[coding]
+		if !ok {
+			q = qs["*"]
+		}
+		if q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+
+	return best
+}
+
+// etagMatches reports whether the If-None-Match header ifNoneMatch matches etag, using the weak
+// comparison RFC 9110 requires for it
+func etagMatches(ifNoneMatch, etag string) bool {
+	if etag == "" || ifNoneMatch == "" {
+		return false
+	}
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(tag), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
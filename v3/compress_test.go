@@ -0,0 +1,229 @@
+package gotoolkit
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+var negotiateEncodingTests = []struct {
+	name     string
+	accept   string
+	expected string
+}{
+	{name: "none", accept: "", expected: ""},
+	{name: "browser", accept: "gzip, deflate, br", expected: "br"},
+	{name: "gzip only", accept: "gzip", expected: "gzip"},
+	{name: "q values", accept: "br;q=0.5, gzip", expected: "gzip"},
+	{name: "wildcard", accept: "*", expected: "br"},
+	{name: "excluded", accept: "br;q=0, *;q=0.1", expected: "gzip"},
+	{name: "identity", accept: "identity", expected: ""},
+	{name: "all excluded", accept: "gzip;q=0", expected: ""},
+	{name: "case", accept: "GZIP", expected: "gzip"},
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	for _, e := range negotiateEncodingTests {
+		if got := negotiateEncoding(e.accept); got != e.expected {
+			t.Errorf("%s: expected %q, got %q", e.name, e.expected, got)
+		}
+	}
+}
+
+func decompress(t *testing.T, encoding string, body io.Reader) string {
+	t.Helper()
+
+	var r io.Reader
+	var err error
+	switch encoding {
+	case "br":
+		r = brotli.NewReader(body)
+	case "gzip":
+		r, err = gzip.NewReader(body)
+	case "deflate":
+		r, err = zlib.NewReader(body)
+	default:
+		r = body
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("%s: %s", encoding, err)
+	}
+	return string(out)
+}
+
+func TestTools_OptimizeResponses(t *testing.T) {
+	testTools := Tools{CompressMinSize: 100}
+	large := map[string]string{"text": strings.Repeat("compress me ", 50)}
+	small := map[string]string{"text": "hi"}
+
+	var tests = []struct {
+		name     string
+		accept   string
+		data     any
+		encoding string
+	}{
+		{name: "brotli", accept: "gzip, deflate, br", data: large, encoding: "br"},
+		{name: "gzip", accept: "gzip", data: large, encoding: "gzip"},
+		{name: "deflate", accept: "deflate", data: large, encoding: "deflate"},
+		{name: "not accepted", accept: "", data: large},
+		{name: "below threshold", accept: "gzip", data: small},
+	}
+
+	for _, e := range tests {
+		handler := testTools.OptimizeResponses(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_ = testTools.WriteJSON(w, http.StatusOK, e.data)
+		}))
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", e.accept)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Header().Get("Content-Encoding") != e.encoding {
+			t.Errorf("%s: expected encoding %q, got %q", e.name, e.encoding, rr.Header().Get("Content-Encoding"))
+		}
+		if rr.Header().Get("Vary") != "Accept-Encoding" {
+			t.Errorf("%s: expected Vary: Accept-Encoding, got %q", e.name, rr.Header().Get("Vary"))
+		}
+		if e.encoding != "" && rr.Header().Get("Content-Length") != "" {
+			t.Errorf("%s: Content-Length of the uncompressed body was sent", e.name)
+		}
+
+		var expected strings.Builder
+		_ = jsonCodec{}.Encode(&expected, e.data)
+		if got := decompress(t, e.encoding, rr.Body); got != expected.String() {
+			t.Errorf("%s: expected %s, got %s", e.name, expected.String(), got)
+		}
+	}
+}
+
+func TestTools_OptimizeResponsesSkipped(t *testing.T) {
+	var testTools Tools
+	image := strings.Repeat("x", 2048)
+
+	var tests = []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{name: "image", handler: func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			_, _ = io.WriteString(w, image)
+		}},
+		{name: "already encoded", handler: func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Encoding", "identity")
+			_, _ = io.WriteString(w, image)
+		}},
+		{name: "byte range", handler: func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(http.StatusPartialContent)
+			_, _ = io.WriteString(w, image)
+		}},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rr := httptest.NewRecorder()
+		testTools.OptimizeResponses(e.handler).ServeHTTP(rr, req)
+
+		if rr.Header().Get("Content-Encoding") == "gzip" || rr.Body.String() != image {
+			t.Errorf("%s: response should not have been compressed", e.name)
+		}
+	}
+}
+
+func TestTools_OptimizeResponsesStream(t *testing.T) {
+	var testTools Tools
+
+	handler := testTools.OptimizeResponses(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := testTools.NewJSONStreamWriter(w, http.StatusOK, NDJSON)
+		_ = s.Write(1)
+		_ = s.Write(2)
+		_ = s.Close()
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if !rr.Flushed || rr.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected a flushed gzip stream, got %v", rr.Header())
+	}
+	if got := decompress(t, "gzip", rr.Body); got != "1\n2\n" {
+		t.Errorf("unexpected stream %q", got)
+	}
+}
+
+func TestTools_ETags(t *testing.T) {
+	testTools := Tools{ETags: true, CompressMinSize: 100}
+	data := map[string]string{"text": strings.Repeat("cache me ", 50)}
+
+	handler := testTools.OptimizeResponses(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = testTools.WriteJSON(w, http.StatusOK, data)
+	}))
+
+	get := func(acceptEncoding, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		req.Header.Set("If-None-Match", ifNoneMatch)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	plain := get("", "")
+	etag := plain.Header().Get("ETag")
+	if plain.Code != http.StatusOK || !strings.HasPrefix(etag, `"`) || strings.HasPrefix(etag, "W/") {
+		t.Fatalf("expected a strong ETag, got %d %q", plain.Code, etag)
+	}
+
+	gzipped := get("gzip", "")
+	gzipETag := gzipped.Header().Get("ETag")
+	if gzipETag == etag || gzipETag != etag[:len(etag)-1]+`-gzip"` {
+		t.Errorf("expected a separate ETag for the gzip representation, got %q", gzipETag)
+	}
+
+	var tests = []struct {
+		name           string
+		acceptEncoding string
+		ifNoneMatch    string
+		status         int
+	}{
+		{name: "match", ifNoneMatch: etag, status: http.StatusNotModified},
+		{name: "match in list", ifNoneMatch: `"other", ` + etag, status: http.StatusNotModified},
+		{name: "weak match", ifNoneMatch: "W/" + etag, status: http.StatusNotModified},
+		{name: "any", ifNoneMatch: "*", status: http.StatusNotModified},
+		{name: "gzip match", acceptEncoding: "gzip", ifNoneMatch: gzipETag, status: http.StatusNotModified},
+		{name: "changed", ifNoneMatch: `"other"`, status: http.StatusOK},
+		{name: "other representation", acceptEncoding: "gzip", ifNoneMatch: etag, status: http.StatusOK},
+	}
+
+	for _, e := range tests {
+		rr := get(e.acceptEncoding, e.ifNoneMatch)
+		if rr.Code != e.status {
+			t.Errorf("%s: expected status %d, got %d", e.name, e.status, rr.Code)
+		}
+		if e.status == http.StatusNotModified && (rr.Body.Len() != 0 || rr.Header().Get("ETag") == "") {
+			t.Errorf("%s: 304 should have an ETag and no body", e.name)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	_ = testTools.WriteJSON(rr, http.StatusCreated, data)
+	if rr.Header().Get("ETag") != "" {
+		t.Error("only 200 OK responses should have an ETag")
+	}
+}
@@ -0,0 +1,116 @@
+package gotoolkit
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// requestBody returns the body of r, limited to MaxJSONSize bytes. A body with a gzip, deflate
+// or br Content-Encoding is decompressed, with the compressed body limited to
+// MaxCompressedJSONSize bytes and the decompressed body to MaxJSONSize, so that a small request
+// cannot expand without limit. Any other encoding is rejected with a *JSONError.
+func (t *Tools) requestBody(w http.ResponseWriter, r *http.Request) (io.Reader, error) {
+	maxBytes := t.maxJSONSize()
+
+	encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+	switch encoding {
+	case "", "identity":
+		r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+		return r.Body, nil
+	case "gzip", "x-gzip", "deflate", "br":
+	default:
+		return nil, &JSONError{
+			Kind:   JSONUnsupportedMediaType,
+			Status: http.StatusUnsupportedMediaType,
+			Err:    fmt.Errorf("unsupported content encoding %q", encoding),
+		}
+	}
+
+	maxCompressed := maxBytes
+	if t.MaxCompressedJSONSize != 0 {
+		maxCompressed = t.MaxCompressedJSONSize
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, int64(maxCompressed))
+	return http.MaxBytesReader(w, &decompressingReader{encoding: encoding, body: r.Body}, int64(maxBytes)), nil
+}
+
+// checkJSONContentType returns a *JSONError unless r has a JSON Content-Type
+func checkJSONContentType(r *http.Request) error {
+	contentType := r.Header.Get("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")) {
+		return nil
+	}
+
+	return &JSONError{
+		Kind:   JSONUnsupportedMediaType,
+		Status: http.StatusUnsupportedMediaType,
+		Err:    fmt.Errorf("unsupported content type %q", contentType),
+	}
+}
+
+// decompressingReader decompresses body, starting on the first Read so that an empty body is
+// still reported as empty. Errors in the compressed data are returned as a *JSONError.
+type decompressingReader struct {
+	encoding string
+	body     io.ReadCloser
+	r        io.Reader
+}
+
+func (d *decompressingReader) Read(p []byte) (int, error) {
+	if d.r == nil {
+		var err error
+		switch d.encoding {
+		case "gzip", "x-gzip":
+			d.r, err = gzip.NewReader(d.body)
+		case "deflate":
+			d.r, err = newDeflateReader(d.body)
+		case "br":
+			d.r = brotli.NewReader(d.body)
+		}
+		if err != nil {
+			return 0, d.wrap(err)
+		}
+	}
+
+	n, err := d.r.Read(p)
+	return n, d.wrap(err)
+}
+
+func (d *decompressingReader) Close() error {
+	return d.body.Close()
+}
+
+func (d *decompressingReader) wrap(err error) error {
+	var maxBytesError *http.MaxBytesError
+	if err == nil || err == io.EOF || errors.As(err, &maxBytesError) {
+		return err
+	}
+	return &JSONError{Kind: JSONInvalidEncoding, Status: http.StatusBadRequest, Err: err}
+}
+
+// newDeflateReader reads deflate data in the zlib format HTTP calls for, or as raw deflate data,
+// which some clients send instead
+func newDeflateReader(body io.Reader) (io.Reader, error) {
+	b := bufio.NewReader(body)
+	header, err := b.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+
+	if header[0]&0x0f == 8 && (int(header[0])<<8|int(header[1]))%31 == 0 {
+		return zlib.NewReader(b)
+	}
+	return flate.NewReader(b), nil
+}
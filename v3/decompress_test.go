@@ -0,0 +1,154 @@
+package gotoolkit
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func compress(t *testing.T, encoding string, data []byte) []byte {
+	t.Helper()
+
+	var b bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&b)
+	case "deflate":
+		w = zlib.NewWriter(&b)
+	case "raw deflate":
+		w, _ = flate.NewWriter(&b, flate.DefaultCompression)
+	case "br":
+		w = brotli.NewWriter(&b)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestTools_ReadJSONCompressed(t *testing.T) {
+	valid := []byte(`{"name": "Sea view", "rooms": 3}`)
+	bomb := []byte(`{"name": "` + strings.Repeat("a", 10<<20) + `"}`)
+
+	// random text does not compress well, so this is small enough once decompressed but too
+	// large before
+	noise := make([]byte, 675)
+	_, _ = rand.Read(noise)
+	incompressible := []byte(`{"name": "` + base64.StdEncoding.EncodeToString(noise) + `"}`)
+
+	var tests = []struct {
+		name     string
+		encoding string
+		body     []byte
+		kind     JSONErrorKind
+		status   int
+	}{
+		{name: "gzip", encoding: "gzip", body: compress(t, "gzip", valid)},
+		{name: "x-gzip", encoding: "x-gzip", body: compress(t, "gzip", valid)},
+		{name: "deflate", encoding: "deflate", body: compress(t, "deflate", valid)},
+		{name: "raw deflate", encoding: "deflate", body: compress(t, "raw deflate", valid)},
+		{name: "brotli", encoding: "br", body: compress(t, "br", valid)},
+		{name: "identity", encoding: "identity", body: valid},
+		{name: "bomb", encoding: "gzip", body: compress(t, "gzip", bomb), kind: JSONTooLarge, status: http.StatusRequestEntityTooLarge},
+		{name: "compressed too large", encoding: "gzip", body: compress(t, "gzip", incompressible), kind: JSONTooLarge, status: http.StatusRequestEntityTooLarge},
+		{name: "corrupt", encoding: "gzip", body: []byte("not gzip at all"), kind: JSONInvalidEncoding, status: http.StatusBadRequest},
+		{name: "truncated", encoding: "gzip", body: compress(t, "gzip", valid)[:20], kind: JSONInvalidEncoding, status: http.StatusBadRequest},
+		{name: "empty", encoding: "gzip", body: nil, kind: JSONEmpty, status: http.StatusBadRequest},
+		{name: "unsupported", encoding: "compress", body: valid, kind: JSONUnsupportedMediaType, status: http.StatusUnsupportedMediaType},
+		{name: "stacked", encoding: "gzip, br", body: valid, kind: JSONUnsupportedMediaType, status: http.StatusUnsupportedMediaType},
+	}
+
+	testTools := Tools{MaxJSONSize: 1024, MaxCompressedJSONSize: 512}
+
+	for _, e := range tests {
+		body := &countingReader{r: bytes.NewReader(e.body)}
+		req := httptest.NewRequest("POST", "/", body)
+		req.Header.Set("Content-Encoding", e.encoding)
+
+		var got testPayload
+		err := testTools.ReadJSON(httptest.NewRecorder(), req, &got)
+
+		if e.kind == 0 {
+			if err != nil || got.Name != "Sea view" || got.Rooms != 3 {
+				t.Errorf("%s: expected the payload, got %+v %v", e.name, got, err)
+			}
+			continue
+		}
+
+		var jsonErr *JSONError
+		if !errors.As(err, &jsonErr) || jsonErr.Kind != e.kind {
+			t.Errorf("%s: expected a %s error, got %v", e.name, e.kind, err)
+			continue
+		}
+		rr := httptest.NewRecorder()
+		_ = testTools.ErrorJSON(rr, err)
+		if rr.Code != e.status {
+			t.Errorf("%s: expected status %d, got %d", e.name, e.status, rr.Code)
+		}
+		if body.n > 64*1024 {
+			t.Errorf("%s: read %d bytes of the body", e.name, body.n)
+		}
+	}
+}
+
+func TestTools_ReadCompressed(t *testing.T) {
+	var testTools Tools
+
+	req := httptest.NewRequest("POST", "/", bytes.NewReader(compress(t, "gzip", []byte(`<payload><name>Sea view</name><rooms>3</rooms></payload>`))))
+	req.Header.Set("Content-Type", "application/xml")
+	req.Header.Set("Content-Encoding", "gzip")
+
+	var got testPayload
+	if err := testTools.Read(httptest.NewRecorder(), req, &got); err != nil || got.Name != "Sea view" {
+		t.Errorf("expected the payload, got %+v %v", got, err)
+	}
+}
+
+func TestTools_RequireJSONContentType(t *testing.T) {
+	testTools := Tools{RequireJSONContentType: true}
+
+	var tests = []struct {
+		contentType string
+		ok          bool
+	}{
+		{contentType: "application/json", ok: true},
+		{contentType: "application/json; charset=utf-8", ok: true},
+		{contentType: "application/vnd.api+json", ok: true},
+		{contentType: "", ok: false},
+		{contentType: "text/plain", ok: false},
+		{contentType: "application/x-www-form-urlencoded", ok: false},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(`{"name": "Sea view"}`))
+		if e.contentType != "" {
+			req.Header.Set("Content-Type", e.contentType)
+		}
+
+		var got testPayload
+		err := testTools.ReadJSON(httptest.NewRecorder(), req, &got)
+
+		var jsonErr *JSONError
+		switch {
+		case e.ok && err != nil:
+			t.Errorf("%q: unexpected error %v", e.contentType, err)
+		case !e.ok && (!errors.As(err, &jsonErr) || jsonErr.Status != http.StatusUnsupportedMediaType):
+			t.Errorf("%q: expected a 415 error, got %v", e.contentType, err)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package gotoolkit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// Download describes content sent by ServeDownload and DownloadFromStorage
+type Download struct {
+	// Name is the file name shown to the user. It may contain any characters.
+	Name string
+	// ContentType is detected from Name, or from the content, when it is empty
+	ContentType string
+	ModTime     time.Time
+	ETag        string
+	// Inline asks the browser to display the content rather than save it
+	Inline bool
+}
+
+// ServeDownload sends content to the client. Range requests, and conditional requests using
+// If-None-Match, If-Modified-Since and If-Range, are answered using d.ETag and d.ModTime.
+func (t *Tools) ServeDownload(w http.ResponseWriter, r *http.Request, content io.ReadSeeker, d Download) {
+	w.Header().Set("Content-Disposition", contentDisposition(d.Inline, d.Name))
+
+	contentType := d.ContentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(path.Ext(d.Name))
+	}
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	if d.ETag != "" {
+		w.Header().Set("ETag", d.ETag)
+	}
+
+	http.ServeContent(w, r, d.Name, d.ModTime, content)
+}
+
+// DownloadFromStorage sends the object stored under key in store, or in t.Storage when store is
+// nil. Any field of d that is empty is filled in from the object, and the name defaults to the
+// last element of the key. If the object cannot be found a 404 response is sent, and if it cannot
+// be read a 500 response is sent; in both cases the error is also returned.
+func (t *Tools) DownloadFromStorage(w http.ResponseWriter, r *http.Request, store Storage, key string, d Download) error {
+	if store == nil {
+		store = t.Storage
+	}
+	if store == nil {
+		err := errors.New("no storage to download from")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return err
+	}
+
+	obj, err := store.Stat(r.Context(), key)
+	if err != nil {
+		if errors.Is(err, ErrObjectNotFound) {
+			http.NotFound(w, r)
+		} else {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+		return err
+	}
+
+	if d.Name == "" {
+		d.Name = path.Base(obj.Key)
+	}
+	if d.ContentType == "" {
+		d.ContentType = obj.ContentType
+	}
+	if d.ModTime.IsZero() {
+		d.ModTime = obj.ModTime
+	}
+	if d.ETag == "" {
+		d.ETag = obj.ETag
+	}
+
+	content := &storageReader{ctx: r.Context(), store: store, key: obj.Key, size: obj.Size}
+	defer content.Close()
+
+	t.ServeDownload(w, r, content, d)
+
+	return nil
+}
+
+// storageReader presents an object in a Storage as an io.ReadSeeker. The object is only opened
+// when it is first read, and is opened again at the new offset after a seek, using a range read
+// when the storage supports one.
+type storageReader struct {
+	ctx    context.Context
+	store  Storage
+	key    string
+	size   int64
+	offset int64
+
+	rc  io.ReadCloser
+	pos int64
+}
+
+func (s *storageReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += s.offset
+	case io.SeekEnd:
+		offset += s.size
+	}
+	if offset < 0 {
+		return 0, errors.New("seek before the start of the object")
+	}
+	s.offset = offset
+	return offset, nil
+}
+
+func (s *storageReader) Read(p []byte) (int, error) {
+	if s.offset >= s.size {
+		return 0, io.EOF
+	}
+
+	if s.rc != nil && s.pos != s.offset {
+		s.Close()
+	}
+	if s.rc == nil {
+		if err := s.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := s.rc.Read(p)
+	s.pos += int64(n)
+	s.offset += int64(n)
+	return n, err
+}
+
+// open opens the object at the current offset
+func (s *storageReader) open() error {
+	if rs, ok := s.store.(RangeStorage); ok && s.offset > 0 {
+		rc, err := rs.GetRange(s.ctx, s.key, s.offset, s.size-s.offset)
+		if err != nil {
+			return err
+		}
+		s.rc, s.pos = rc, s.offset
+		return nil
+	}
+
+	rc, _, err := s.store.Get(s.ctx, s.key)
+	if err != nil {
+		return err
+	}
+
+	if seeker, ok := rc.(io.Seeker); ok {
+		_, err = seeker.Seek(s.offset, io.SeekStart)
+	} else {
+		_, err = io.CopyN(io.Discard, rc, s.offset)
+	}
+	if err != nil {
+		rc.Close()
+		return err
+	}
+
+	s.rc, s.pos = rc, s.offset
+	return nil
+}
+
+func (s *storageReader) Close() error {
+	if s.rc == nil {
+		return nil
+	}
+	err := s.rc.Close()
+	s.rc = nil
+	return err
+}
+
+// contentDisposition builds a Content-Disposition header value as described by RFC 6266. Names
+// that are not plain ASCII are sent with an ASCII fallback in filename, and in full in filename*.
+func contentDisposition(inline bool, name string) string {
+	disposition := "attachment"
+	if inline {
+		disposition = "inline"
+	}
+	if name == "" {
+		return disposition
+	}
+
+	fallback := strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e || r == '"' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, name)
+
+	value := fmt.Sprintf("%s; filename=\"%s\"", disposition, fallback)
+	if fallback != name {
+		value += "; filename*=UTF-8''" + encodeRFC5987(name)
+	}
+	return value
+}
+
+// encodeRFC5987 percent encodes s for use in an extended header parameter, as described by RFC 5987
+func encodeRFC5987(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			strings.IndexByte("!#$&+-.^_`|~", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,156 @@
+package gotoolkit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var dispositionTests = []struct {
+	name     string
+	inline   bool
+	fileName string
+	expected string
+}{
+	{name: "plain", fileName: "puppy.jpg", expected: `attachment; filename="puppy.jpg"`},
+	{name: "inline", inline: true, fileName: "puppy.jpg", expected: `inline; filename="puppy.jpg"`},
+	{name: "no name", inline: true, expected: `inline`},
+	{name: "quotes", fileName: `my "best" puppy.jpg`, expected: `attachment; filename="my _best_ puppy.jpg"; filename*=UTF-8''my%20%22best%22%20puppy.jpg`},
+	{name: "non ascii", fileName: "chiot é.jpg", expected: `attachment; filename="chiot _.jpg"; filename*=UTF-8''chiot%20%C3%A9.jpg`},
+	{name: "header injection", fileName: "a\r\nSet-Cookie: x.jpg", expected: `attachment; filename="a__Set-Cookie: x.jpg"; filename*=UTF-8''a%0D%0ASet-Cookie%3A%20x.jpg`},
+}
+
+func TestContentDisposition(t *testing.T) {
+	for _, e := range dispositionTests {
+		if got := contentDisposition(e.inline, e.fileName); got != e.expected {
+			t.Errorf("%s: expected %s, got %s", e.name, e.expected, got)
+		}
+	}
+}
+
+var downloadTests = []struct {
+	name     string
+	header   map[string]string
+	status   int
+	expected string
+}{
+	{name: "full", status: http.StatusOK, expected: "0123456789"},
+	{name: "range", header: map[string]string{"Range": "bytes=2-5"}, status: http.StatusPartialContent, expected: "2345"},
+	{name: "suffix range", header: map[string]string{"Range": "bytes=-3"}, status: http.StatusPartialContent, expected: "789"},
+	{name: "unsatisfiable range", header: map[string]string{"Range": "bytes=20-30"}, status: http.StatusRequestedRangeNotSatisfiable},
+	{name: "if none match", header: map[string]string{"If-None-Match": `"v1"`}, status: http.StatusNotModified},
+	{name: "if none match changed", header: map[string]string{"If-None-Match": `"v0"`}, status: http.StatusOK, expected: "0123456789"},
+	{name: "if modified since", header: map[string]string{"If-Modified-Since": "Mon, 02 Jan 2023 15:04:05 GMT"}, status: http.StatusNotModified},
+	{name: "if range match", header: map[string]string{"Range": "bytes=8-", "If-Range": `"v1"`}, status: http.StatusPartialContent, expected: "89"},
+	{name: "if range changed", header: map[string]string{"Range": "bytes=8-", "If-Range": `"v0"`}, status: http.StatusOK, expected: "0123456789"},
+}
+
+func TestTools_ServeDownload(t *testing.T) {
+	var testTools Tools
+	modTime := time.Date(2023, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	for _, e := range downloadTests {
+		req := httptest.NewRequest("GET", "/", nil)
+		for k, v := range e.header {
+			req.Header.Set(k, v)
+		}
+		rr := httptest.NewRecorder()
+
+		testTools.ServeDownload(rr, req, strings.NewReader("0123456789"), Download{Name: "digits.txt", ETag: `"v1"`, ModTime: modTime})
+
+		if rr.Code != e.status {
+			t.Errorf("%s: expected status %d, got %d", e.name, e.status, rr.Code)
+		}
+		if e.expected != "" && rr.Body.String() != e.expected {
+			t.Errorf("%s: expected body %q, got %q", e.name, e.expected, rr.Body.String())
+		}
+		if rr.Header().Get("ETag") != `"v1"` {
+			t.Errorf("%s: missing etag %v", e.name, rr.Header())
+		}
+		if e.status == http.StatusOK && !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/plain") {
+			t.Errorf("%s: wrong content type %s", e.name, rr.Header().Get("Content-Type"))
+		}
+	}
+}
+
+func TestTools_DownloadFromStorage(t *testing.T) {
+	var testTools Tools
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+
+	for name, store := range testStorages(t) {
+		if _, err := store.Put(context.Background(), "files/digits.txt", bytes.NewReader(data), int64(len(data)), "text/plain"); err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/", nil)
+		if err := testTools.DownloadFromStorage(rr, req, store, "files/digits.txt", Download{Name: "numéros.txt", Inline: true}); err != nil {
+			t.Errorf("%s: %s", name, err)
+		}
+		if rr.Code != http.StatusOK || !bytes.Equal(rr.Body.Bytes(), data) {
+			t.Errorf("%s: wrong response %d, %d bytes", name, rr.Code, rr.Body.Len())
+		}
+		if got := rr.Header().Get("Content-Disposition"); got != `inline; filename="num_ros.txt"; filename*=UTF-8''num%C3%A9ros.txt` {
+			t.Errorf("%s: wrong content disposition %s", name, got)
+		}
+		etag := rr.Header().Get("ETag")
+		if etag == "" {
+			t.Errorf("%s: no etag", name)
+		}
+
+		rr = httptest.NewRecorder()
+		req = httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Range", "bytes=5005-5009,9998-")
+		if err := testTools.DownloadFromStorage(rr, req, store, "files/digits.txt", Download{}); err != nil {
+			t.Errorf("%s: %s", name, err)
+		}
+		if rr.Code != http.StatusPartialContent || !strings.Contains(rr.Body.String(), "\r\n\r\n56789\r\n") || !strings.Contains(rr.Body.String(), "\r\n\r\n89\r\n") {
+			t.Errorf("%s: wrong multipart range response %d %q", name, rr.Code, rr.Body.String())
+		}
+		if got := rr.Header().Get("Content-Disposition"); got != `attachment; filename="digits.txt"` {
+			t.Errorf("%s: wrong default content disposition %s", name, got)
+		}
+
+		rr = httptest.NewRecorder()
+		req = httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("If-None-Match", etag)
+		_ = testTools.DownloadFromStorage(rr, req, store, "files/digits.txt", Download{})
+		if rr.Code != http.StatusNotModified {
+			t.Errorf("%s: expected 304, got %d", name, rr.Code)
+		}
+
+		rr = httptest.NewRecorder()
+		req = httptest.NewRequest("GET", "/", nil)
+		err := testTools.DownloadFromStorage(rr, req, store, "files/missing.txt", Download{})
+		if !errors.Is(err, ErrObjectNotFound) || rr.Code != http.StatusNotFound {
+			t.Errorf("%s: expected not found, got %d %v", name, rr.Code, err)
+		}
+	}
+}
+
+func TestStorageReader_Seek(t *testing.T) {
+	store := &MemoryStorage{}
+	_, _ = store.Put(context.Background(), "a.txt", strings.NewReader("0123456789"), 10, "")
+
+	s := &storageReader{ctx: context.Background(), store: store, key: "a.txt", size: 10}
+	defer s.Close()
+
+	buf := make([]byte, 3)
+	for _, offset := range []int64{7, 2, 4} {
+		if _, err := s.Seek(offset, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadFull(s, buf); err != nil {
+			t.Fatal(err)
+		}
+		if expected := "0123456789"[offset : offset+3]; string(buf) != expected {
+			t.Errorf("at %d: expected %s, got %s", offset, expected, buf)
+		}
+	}
+}
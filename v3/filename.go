@@ -0,0 +1,134 @@
+package gotoolkit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// ErrFileExists is returned when an uploaded file would replace an existing file, and
+// Tools.FileNameCollision is CollisionError
+var ErrFileExists = errors.New("a file with that name already exists")
+
+// FileNameCollision decides what happens when an upload that keeps its original file name would
+// replace a file that already exists
+type FileNameCollision int
+
+const (
+	// CollisionSuffix, the default, adds a counter to the name, so photo.jpg is saved as
+	// photo-1.jpg
+	CollisionSuffix FileNameCollision = iota
+	// CollisionError rejects the upload with ErrFileExists
+	CollisionError
+	// CollisionOverwrite replaces the existing file
+	CollisionOverwrite
+)
+
+// maxFileNameLength is the length, in bytes, allowed by most file systems
+const maxFileNameLength = 255
+
+// windowsReservedNames cannot be used as file names on Windows, with or without an extension
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// SanitizeFileName turns a file name sent by a client into one that is safe to save. Any directory
+// part is removed, the name is normalised to Unicode NFC, control characters are dropped, and
+// characters that are not allowed in file names on common systems are replaced with underscores.
+// Leading dots (hidden files) and trailing dots and spaces are removed, reserved Windows device
+// names get an underscore prefix, and long names are shortened, keeping their extension.
+func (t *Tools) SanitizeFileName(s string) string {
+	s = norm.NFC.String(s)
+
+	s = strings.ReplaceAll(s, "\\", "/")
+	if i := strings.LastIndex(s, "/"); i >= 0 {
+		s = s[i+1:]
+	}
+
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r == utf8.RuneError || unicode.IsControl(r):
+			return -1
+		case strings.ContainsRune(`<>:"|?*`, r):
+			return '_'
+		}
+		return r
+	}, s)
+
+	s = strings.TrimLeft(s, ". ")
+	s = strings.TrimRight(s, ". ")
+
+	ext := path.Ext(s)
+	base := strings.TrimSuffix(s, ext)
+	if windowsReservedNames[strings.ToUpper(strings.SplitN(base, ".", 2)[0])] {
+		base = "_" + base
+	}
+
+	if len(ext) > maxFileNameLength/2 {
+		ext = ""
+	}
+	for len(base)+len(ext) > maxFileNameLength {
+		_, size := utf8.DecodeLastRuneInString(base)
+		base = base[:len(base)-size]
+	}
+
+	if base == "" {
+		base = "file"
+	}
+
+	return base + ext
+}
+
+// resolveCollision returns the key an upload that keeps its own name should be saved as, following
+// t.FileNameCollision. The check is made just before the file is saved, so two requests saving
+// the same name at the same moment may still collide. A transactional upload never overwrites,
+// as rolling it back would delete the file it replaced; CollisionOverwrite acts as CollisionError.
+func (t *Tools) resolveCollision(ctx context.Context, store Storage, key string) (string, error) {
+	policy := t.FileNameCollision
+	if policy == CollisionOverwrite {
+		if !t.TransactionalUploads {
+			return key, nil
+		}
+		policy = CollisionError
+	}
+
+	exists := func(k string) (bool, error) {
+		_, err := store.Stat(ctx, k)
+		if errors.Is(err, ErrObjectNotFound) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	found, err := exists(key)
+	if err != nil || !found {
+		return key, err
+	}
+
+	if policy == CollisionError {
+		return "", fmt.Errorf("%w: %s", ErrFileExists, path.Base(key))
+	}
+
+	ext := path.Ext(key)
+	base := strings.TrimSuffix(key, ext)
+	for n := 1; n <= 10000; n++ {
+		candidate := fmt.Sprintf("%s-%d%s", base, n, ext)
+		found, err := exists(candidate)
+		if err != nil {
+			return "", err
+		}
+		if !found {
+			return candidate, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: %s", ErrFileExists, path.Base(key))
+}
@@ -0,0 +1,76 @@
+package gotoolkit
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+var sanitizeTests = []struct {
+	name     string
+	s        string
+	expected string
+}{
+	{name: "plain", s: "photo.jpg", expected: "photo.jpg"},
+	{name: "unix path", s: "../../etc/passwd", expected: "passwd"},
+	{name: "windows path", s: `C:\Users\me\photo.jpg`, expected: "photo.jpg"},
+	{name: "control characters", s: "pho\x00to\n.jpg", expected: "photo.jpg"},
+	{name: "reserved characters", s: `a<b>c:d"e|f?g*.txt`, expected: "a_b_c_d_e_f_g_.txt"},
+	{name: "hidden file", s: ".htaccess", expected: "htaccess"},
+	{name: "trailing dots", s: "report.pdf. . ", expected: "report.pdf"},
+	{name: "reserved device", s: "con.txt", expected: "_con.txt"},
+	{name: "reserved device double extension", s: "LPT1.tar.gz", expected: "_LPT1.tar.gz"},
+	{name: "dot dot", s: "..", expected: "file"},
+	{name: "empty", s: "", expected: "file"},
+	{name: "unicode normalised", s: "cafe\u0301.jpg", expected: "caf\u00e9.jpg"},
+	{name: "long name", s: strings.Repeat("a", 300) + ".jpg", expected: strings.Repeat("a", 251) + ".jpg"},
+}
+
+func TestTools_SanitizeFileName(t *testing.T) {
+	var testTools Tools
+
+	for _, e := range sanitizeTests {
+		if got := testTools.SanitizeFileName(e.s); got != e.expected {
+			t.Errorf("%s: expected %q, got %q", e.name, e.expected, got)
+		}
+	}
+}
+
+func TestTools_UploadFilesFileNameCollision(t *testing.T) {
+	upload := func(testTools *Tools) (*UploadedFile, error) {
+		request := multipartRequest(t, testPart{field: "file", fileName: "dir/photo.jpg", data: []byte("a photo")})
+		return testTools.UploadOneFile(request, "listings", false)
+	}
+
+	store := &MemoryStorage{}
+	// CollisionSuffix is the default
+	testTools := Tools{Storage: store}
+
+	for i, expected := range []string{"photo.jpg", "photo-1.jpg", "photo-2.jpg"} {
+		f, err := upload(&testTools)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.NewFileName != expected || f.Key != "listings/"+expected {
+			t.Errorf("upload %d: expected %s, got %s (%s)", i, expected, f.NewFileName, f.Key)
+		}
+		// mime/multipart drops the directory the client sent
+		if f.OriginalFileName != "photo.jpg" {
+			t.Errorf("upload %d: expected original name photo.jpg, got %s", i, f.OriginalFileName)
+		}
+	}
+
+	testTools.FileNameCollision = CollisionError
+	if _, err := upload(&testTools); !errors.Is(err, ErrFileExists) {
+		t.Errorf("expected ErrFileExists, got %v", err)
+	}
+
+	testTools.FileNameCollision = CollisionOverwrite
+	f, err := upload(&testTools)
+	if err != nil || f.NewFileName != "photo.jpg" {
+		t.Errorf("expected photo.jpg to be overwritten, got %v %v", f, err)
+	}
+	if len(store.Keys()) != 3 {
+		t.Errorf("expected 3 stored files, got %v", store.Keys())
+	}
+}
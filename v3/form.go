@@ -0,0 +1,136 @@
+package gotoolkit
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// maxFormValueBytes limits the combined size of the plain values in a streamed upload, matching
+// the limit net/http applies to them when parsing a multipart form
+const maxFormValueBytes = 10 << 20
+
+// FieldRule sets the rules for the files sent in one form field. AllowedFileTypes replaces
+// Tools.AllowedFileTypes for the field, MaxFiles limits how many files it may hold (zero means no
+// limit), and Required rejects an upload without at least one file in the field.
+type FieldRule struct {
+	AllowedFileTypes []string
+	MaxFiles         int
+	Required         bool
+}
+
+// UploadFieldError is returned when the files of an upload break a FieldRule, or are sent in a
+// field that Tools.UploadFields does not list
+type UploadFieldError struct {
+	Field  string
+	Reason string
+}
+
+func (e *UploadFieldError) Error() string {
+	return fmt.Sprintf("the upload field %q %s", e.Field, e.Reason)
+}
+
+// UploadedForm is the result of UploadForm: the saved files, and the plain values sent with them
+type UploadedForm struct {
+	Files  []*UploadedFile
+	Values url.Values
+
+	t *Tools
+}
+
+// FieldFiles returns the files that were sent in the named form field
+func (f *UploadedForm) FieldFiles(field string) []*UploadedFile {
+	var files []*UploadedFile
+	for _, file := range f.Files {
+		if file.FieldName == field {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// DecodeJSON decodes the JSON held in the named form value, such as a "metadata" part, into data.
+// It follows the same rules as ReadJSON: the value may not be larger than MaxJSONSize, must hold
+// a single JSON value, and may only contain unknown keys if AllowUnknownFields is set.
+func (f *UploadedForm) DecodeJSON(field string, data any) error {
+	if _, ok := f.Values[field]; !ok {
+		return fmt.Errorf("form field %q is missing", field)
+	}
+
+	body := http.MaxBytesReader(nil, io.NopCloser(strings.NewReader(f.Values.Get(field))), int64(f.t.maxJSONSize()))
+
+	return f.t.decodeJSON(body, data)
+}
+
+// UploadForm works like UploadFiles, but also returns the plain (non file) values sent in the
+// form. Each file records the form field it was sent in. As with UploadFiles, an error that stops
+// the upload part way, or a required field found missing at the end, is returned with the form
+// read so far, whose Files have been saved unless t.TransactionalUploads has removed them.
+func (t *Tools) UploadForm(r *http.Request, uploadDir string, rename ...bool) (*UploadedForm, error) {
+	renameFile := true
+	if len(rename) > 0 {
+		renameFile = rename[0]
+	}
+
+	u, err := t.upload(r, uploadDir, renameFile)
+	if u == nil {
+		return nil, err
+	}
+
+	return &UploadedForm{Files: u.files, Values: u.values, t: t}, err
+}
+
+// fieldRule returns the rule for files in the named field. Files from a source with no form
+// field, such as a tus upload, are not subject to field rules.
+func (u *uploader) fieldRule(field string) (*FieldRule, error) {
+	if u.t.UploadFields == nil || field == "" {
+		return nil, nil
+	}
+
+	rule, ok := u.t.UploadFields[field]
+	if !ok {
+		return nil, &UploadFieldError{Field: field, Reason: "is not expected"}
+	}
+
+	return &rule, nil
+}
+
+// checkRequiredFields returns an *UploadFieldError for the first required field without a file
+func (u *uploader) checkRequiredFields() error {
+	var fields []string
+	for field, rule := range u.t.UploadFields {
+		if rule.Required && u.fieldCounts[field] == 0 {
+			fields = append(fields, field)
+		}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+
+	sort.Strings(fields)
+	return &UploadFieldError{Field: fields[0], Reason: "must contain a file"}
+}
+
+// addValue records a plain form value read from a streamed upload
+func (u *uploader) addValue(field string, r io.Reader) error {
+	remaining := int64(maxFormValueBytes) - u.valueBytes
+	b, err := io.ReadAll(io.LimitReader(r, remaining+1))
+	if err != nil {
+		return err
+	}
+
+	u.valueBytes += int64(len(b))
+	if u.valueBytes > maxFormValueBytes {
+		return fmt.Errorf("the form values are larger than %d bytes", maxFormValueBytes)
+	}
+
+	if u.values == nil {
+		u.values = make(url.Values)
+	}
+	u.values.Add(field, string(b))
+
+	return nil
+}
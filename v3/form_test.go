@@ -0,0 +1,184 @@
+package gotoolkit
+
+import (
+	"errors"
+	"testing"
+)
+
+var uploadFormTests = []struct {
+	name          string
+	fields        map[string]FieldRule
+	parts         []testPart
+	errorExpected bool
+	field         string
+}{
+	{
+		name:   "no rules",
+		fields: nil,
+		parts:  []testPart{{field: "anything", fileName: "a.txt", data: []byte("text")}},
+	},
+	{
+		name:   "allowed",
+		fields: map[string]FieldRule{"floorplan": {AllowedFileTypes: []string{"image/png"}, Required: true}, "photos[]": {MaxFiles: 2}},
+		parts: []testPart{
+			{field: "floorplan", fileName: "plan.png"},
+			{field: "photos[]", fileName: "a.jpg"},
+			{field: "photos[]", fileName: "b.jpg"},
+		},
+	},
+	{
+		name:          "unknown field",
+		fields:        map[string]FieldRule{"photos[]": {}},
+		parts:         []testPart{{field: "other", fileName: "a.jpg"}},
+		errorExpected: true,
+		field:         "other",
+	},
+	{
+		name:          "too many files",
+		fields:        map[string]FieldRule{"photos[]": {MaxFiles: 1}},
+		parts:         []testPart{{field: "photos[]", fileName: "a.jpg"}, {field: "photos[]", fileName: "b.jpg"}},
+		errorExpected: true,
+		field:         "photos[]",
+	},
+	{
+		name:          "required field missing",
+		fields:        map[string]FieldRule{"floorplan": {Required: true}, "photos[]": {}},
+		parts:         []testPart{{field: "photos[]", fileName: "a.jpg"}},
+		errorExpected: true,
+		field:         "floorplan",
+	},
+	{
+		name:          "field type not allowed",
+		fields:        map[string]FieldRule{"floorplan": {AllowedFileTypes: []string{"image/png"}}},
+		parts:         []testPart{{field: "floorplan", fileName: "a.jpg"}},
+		errorExpected: true,
+	},
+}
+
+func TestTools_UploadForm(t *testing.T) {
+	png := readTestFile(t, "testdata/img.png")
+	jpg := readTestFile(t, "testdata/pic.jpg")
+
+	for _, stream := range []bool{false, true} {
+		for _, e := range uploadFormTests {
+			parts := make([]testPart, len(e.parts))
+			for i, p := range e.parts {
+				p.data = jpg
+				if p.fileName == "plan.png" {
+					p.data = png
+				}
+				parts[i] = p
+			}
+			parts = append(parts, testPart{field: "title", data: []byte("Sea view")})
+
+			testTools := Tools{Storage: &MemoryStorage{}, UploadFields: e.fields, StreamUploads: stream}
+			form, err := testTools.UploadForm(multipartRequest(t, parts...), "uploads")
+
+			if e.errorExpected {
+				if err == nil {
+					t.Errorf("%s (stream %v): error expected but none received", e.name, stream)
+				}
+				var fieldErr *UploadFieldError
+				if e.field != "" && (!errors.As(err, &fieldErr) || fieldErr.Field != e.field) {
+					t.Errorf("%s (stream %v): expected an error for field %s, got %v", e.name, stream, e.field, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("%s (stream %v): %s", e.name, stream, err)
+				continue
+			}
+
+			if len(form.Files) != len(e.parts) {
+				t.Errorf("%s (stream %v): expected %d files, got %d", e.name, stream, len(e.parts), len(form.Files))
+			}
+			for _, p := range e.parts {
+				found := false
+				for _, f := range form.FieldFiles(p.field) {
+					found = found || f.OriginalFileName == p.fileName
+				}
+				if !found {
+					t.Errorf("%s (stream %v): %s was not returned for field %s", e.name, stream, p.fileName, p.field)
+				}
+			}
+			if got := form.Values.Get("title"); got != "Sea view" {
+				t.Errorf("%s (stream %v): expected title value, got %q", e.name, stream, got)
+			}
+		}
+	}
+}
+
+func TestTools_UploadFormRequiredFieldMissing(t *testing.T) {
+	for _, transactional := range []bool{false, true} {
+		store := &MemoryStorage{}
+		testTools := Tools{
+			Storage:              store,
+			UploadFields:         map[string]FieldRule{"floorplan": {Required: true}, "photos[]": {}},
+			TransactionalUploads: transactional,
+		}
+		request := multipartRequest(t,
+			testPart{field: "photos[]", fileName: "a.jpg", data: readTestFile(t, "testdata/pic.jpg")},
+			testPart{field: "title", data: []byte("Sea view")},
+		)
+
+		form, err := testTools.UploadForm(request, "uploads")
+
+		var fieldErr *UploadFieldError
+		if !errors.As(err, &fieldErr) || fieldErr.Field != "floorplan" {
+			t.Errorf("transactional %v: expected an error for floorplan, got %v", transactional, err)
+		}
+		if form == nil {
+			t.Fatalf("transactional %v: expected the partial form", transactional)
+		}
+		if form.Values.Get("title") != "Sea view" {
+			t.Errorf("transactional %v: expected the title value, got %v", transactional, form.Values)
+		}
+
+		if transactional {
+			if len(form.Files) != 0 || len(store.Keys()) != 0 {
+				t.Errorf("expected the saved photo to be removed, got %v %v", form.Files, store.Keys())
+			}
+			continue
+		}
+		if len(form.Files) != 1 || len(store.Keys()) != 1 || store.Keys()[0] != form.Files[0].Key {
+			t.Errorf("expected the saved photo to be reported, got %v %v", form.Files, store.Keys())
+		}
+	}
+}
+
+func TestUploadedForm_DecodeJSON(t *testing.T) {
+	var testTools Tools
+
+	request := multipartRequest(t,
+		testPart{field: "photo", fileName: "a.jpg", data: readTestFile(t, "testdata/pic.jpg")},
+		testPart{field: "metadata", data: []byte(`{"title": "Sea view", "rooms": 3}`)},
+		testPart{field: "extra", data: []byte(`{"title": "Sea view", "garden": true}`)},
+	)
+	testTools.Storage = &MemoryStorage{}
+	form, err := testTools.UploadForm(request, "uploads")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if files := form.FieldFiles("photo"); len(files) != 1 {
+		t.Errorf("expected one photo, got %d", len(files))
+	}
+
+	var metadata struct {
+		Title string `json:"title"`
+		Rooms int    `json:"rooms"`
+	}
+	if err := form.DecodeJSON("metadata", &metadata); err != nil {
+		t.Fatal(err)
+	}
+	if metadata.Title != "Sea view" || metadata.Rooms != 3 {
+		t.Errorf("unexpected metadata %+v", metadata)
+	}
+
+	if err := form.DecodeJSON("extra", &metadata); err == nil {
+		t.Error("expected an error for an unknown key")
+	}
+	if err := form.DecodeJSON("missing", &metadata); err == nil {
+		t.Error("expected an error for a missing field")
+	}
+}
@@ -0,0 +1,354 @@
+package gotoolkit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"path"
+	"strings"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// ImageOptions configures the processing applied to uploaded images when Tools.ImageProcessing
+// is set. JPEG, PNG, GIF and WebP images are recognised; other files are saved untouched.
+//
+// JPEG and PNG images are decoded and encoded again, which removes any EXIF (including GPS) and
+// other metadata. WebP images cannot be encoded by the standard library, so instead their EXIF
+// and XMP chunks are removed. GIF images carry no EXIF data and are saved as they are.
+type ImageOptions struct {
+	// MaxWidth and MaxHeight reject images larger than this many pixels. Zero means no limit.
+	MaxWidth  int
+	MaxHeight int
+	// MaxPixels rejects images with more pixels than this in total, before they are decoded, as a
+	// small file can declare a huge image. Defaults to 50 million; a negative value means no limit.
+	MaxPixels int
+	// StripMetadata removes EXIF, GPS and other metadata from saved images
+	StripMetadata bool
+	// AutoRotate turns JPEG images upright according to their EXIF orientation tag
+	AutoRotate bool
+	// JPEGQuality is the quality used when encoding JPEG images and thumbnails. Defaults to 85.
+	JPEGQuality int
+	// Thumbnails are the smaller variants to generate for every image
+	Thumbnails []Thumbnail
+}
+
+// Thumbnail describes a resized variant of an uploaded image. The image is scaled down, keeping
+// its aspect ratio, to fit within Width by Height; a zero Width or Height leaves that side free.
+type Thumbnail struct {
+	Name   string
+	Width  int
+	Height int
+}
+
+// ImageVariant reports a thumbnail generated for an uploaded image
+type ImageVariant struct {
+	Name     string
+	Key      string
+	URL      string
+	Width    int
+	Height   int
+	FileSize int64
+}
+
+// ImageSizeError is returned when an uploaded image is larger than ImageOptions.MaxWidth,
+// ImageOptions.MaxHeight or ImageOptions.MaxPixels
+type ImageSizeError struct {
+	FileName  string
+	Width     int
+	Height    int
+	MaxWidth  int
+	MaxHeight int
+	MaxPixels int
+}
+
+func (e *ImageSizeError) Error() string {
+	if e.MaxPixels > 0 {
+		return fmt.Sprintf("the uploaded image %s is %dx%d pixels, more than the permitted %d pixels",
+			e.FileName, e.Width, e.Height, e.MaxPixels)
+	}
+	return fmt.Sprintf("the uploaded image %s is %dx%d pixels, larger than the permitted %dx%d",
+		e.FileName, e.Width, e.Height, e.MaxWidth, e.MaxHeight)
+}
+
+// isProcessableImage reports whether fileType is an image format that ImageOptions applies to
+func isProcessableImage(fileType string) bool {
+	switch fileType {
+	case "image/jpeg", "image/png", "image/gif", "image/webp":
+		return true
+	}
+	return false
+}
+
+// processImage applies opts to the image held in data. It returns the content that should be
+// saved in place of data, and the decoded (and rotated) image for generating thumbnails.
+func processImage(opts *ImageOptions, fileName string, data []byte) ([]byte, image.Image, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, fmt.Errorf("the uploaded image %s could not be decoded: %w", fileName, err)
+	}
+
+	if (opts.MaxWidth > 0 && cfg.Width > opts.MaxWidth) || (opts.MaxHeight > 0 && cfg.Height > opts.MaxHeight) {
+		return nil, nil, &ImageSizeError{FileName: fileName, Width: cfg.Width, Height: cfg.Height, MaxWidth: opts.MaxWidth, MaxHeight: opts.MaxHeight}
+	}
+
+	maxPixels := 50_000_000
+	if opts.MaxPixels != 0 {
+		maxPixels = opts.MaxPixels
+	}
+	if maxPixels > 0 && int64(cfg.Width)*int64(cfg.Height) > int64(maxPixels) {
+		return nil, nil, &ImageSizeError{FileName: fileName, Width: cfg.Width, Height: cfg.Height, MaxPixels: maxPixels}
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, fmt.Errorf("the uploaded image %s could not be decoded: %w", fileName, err)
+	}
+
+	reencode := opts.StripMetadata
+	if opts.AutoRotate && format == "jpeg" {
+		if o := exifOrientation(data); o > 1 {
+			img = applyOrientation(img, o)
+			reencode = true
+		}
+	}
+
+	switch {
+	case format == "webp" && opts.StripMetadata:
+		data, err = stripWebPMetadata(data)
+		if err != nil {
+			return nil, nil, err
+		}
+	case (format == "jpeg" || format == "png") && reencode:
+		var buf bytes.Buffer
+		if err := encodeImage(&buf, format, img, opts.JPEGQuality); err != nil {
+			return nil, nil, err
+		}
+		data = buf.Bytes()
+	}
+
+	return data, img, nil
+}
+
+// encodeImage writes img to w in the named format
+func encodeImage(w io.Writer, format string, img image.Image, quality int) error {
+	switch format {
+	case "png":
+		return png.Encode(w, img)
+	case "gif":
+		return gif.Encode(w, img, nil)
+	default:
+		if quality <= 0 {
+			quality = 85
+		}
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	}
+}
+
+// thumbnailFormat returns the format and extension used for thumbnails of a fileType image.
+// Formats that may be transparent get PNG thumbnails; everything else gets JPEG.
+func thumbnailFormat(fileType string) (string, string) {
+	if fileType == "image/png" || fileType == "image/gif" {
+		return "png", ".png"
+	}
+	return "jpeg", ".jpg"
+}
+
+// thumbnailKey names a variant after the key of the original, so "a/b.jpg" becomes "a/b_small.jpg"
+func thumbnailKey(key, name, ext string) string {
+	return strings.TrimSuffix(key, path.Ext(key)) + "_" + name + ext
+}
+
+// resizeToFit scales img down, keeping its aspect ratio, so that it fits within width by height
+func resizeToFit(img image.Image, width, height int) image.Image {
+	b := img.Bounds()
+	nw, nh := fitSize(b.Dx(), b.Dy(), width, height)
+	if nw == b.Dx() && nh == b.Dy() {
+		return img
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, nw, nh))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Over, nil)
+
+	return dst
+}
+
+// fitSize returns the size of a w by h image scaled down, keeping its aspect ratio, to fit within
+// width by height. A zero width or height leaves that side free.
+func fitSize(w, h, width, height int) (int, int) {
+	scale := 1.0
+	if width > 0 && w > width {
+		scale = float64(width) / float64(w)
+	}
+	if height > 0 && float64(h)*scale > float64(height) {
+		scale = float64(height) / float64(h)
+	}
+	if scale >= 1 {
+		return w, h
+	}
+
+	nw, nh := int(float64(w)*scale+0.5), int(float64(h)*scale+0.5)
+	if nw < 1 {
+		nw = 1
+	}
+	if nh < 1 {
+		nh = 1
+	}
+	return nw, nh
+}
+
+// exifOrientation returns the orientation tag (1 to 8) from the EXIF data of a JPEG image,
+// or 0 if there is none
+func exifOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 0
+	}
+
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 0
+		}
+		marker := data[i+1]
+		if marker == 0xDA || marker == 0xD9 {
+			// the image data starts, or ends, without any EXIF
+			return 0
+		}
+
+		size := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + size
+		if size < 2 || end > len(data) {
+			return 0
+		}
+
+		segment := data[i+4 : end]
+		if marker == 0xE1 && len(segment) > 6 && string(segment[:6]) == "Exif\x00\x00" {
+			return tiffOrientation(segment[6:])
+		}
+
+		i = end
+	}
+
+	return 0
+}
+
+// tiffOrientation reads the orientation tag from the first IFD of a TIFF header
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+
+	offset := int(order.Uint32(tiff[4:]))
+	if offset+2 > len(tiff) {
+		return 0
+	}
+
+	entries := int(order.Uint16(tiff[offset:]))
+	for n := 0; n < entries; n++ {
+		entry := offset + 2 + n*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			o := int(order.Uint16(tiff[entry+8:]))
+			if o < 1 || o > 8 {
+				return 0
+			}
+			return o
+		}
+	}
+
+	return 0
+}
+
+// applyOrientation transforms img so that it is displayed upright, given its EXIF orientation
+func applyOrientation(img image.Image, orientation int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			default:
+				sx, sy = x, y
+			}
+			dst.Set(x, y, img.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+
+	return dst
+}
+
+// stripWebPMetadata removes the EXIF and XMP chunks from a WebP file, and clears the flags that
+// announce them
+func stripWebPMetadata(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, fmt.Errorf("not a valid WebP image")
+	}
+
+	out := make([]byte, 12, len(data))
+	copy(out, data[:12])
+
+	for i := 12; i+8 <= len(data); {
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + size + size%2
+		if end > len(data) {
+			end = len(data)
+		}
+
+		switch string(data[i : i+4]) {
+		case "EXIF", "XMP ":
+			// dropped
+		case "VP8X":
+			chunk := append([]byte(nil), data[i:end]...)
+			if len(chunk) > 8 {
+				chunk[8] &^= 0x08 | 0x04
+			}
+			out = append(out, chunk...)
+		default:
+			out = append(out, data[i:end]...)
+		}
+
+		i = end
+	}
+
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+
+	return out, nil
+}
@@ -0,0 +1,212 @@
+package gotoolkit
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"testing"
+)
+
+// jpegWithOrientation encodes a width by height JPEG, whose left half is red, with an EXIF
+// segment carrying the given orientation
+func jpegWithOrientation(t *testing.T, width, height, orientation int) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if x < width/2 {
+				img.Set(x, y, color.RGBA{R: 255, A: 255})
+			} else {
+				img.Set(x, y, color.RGBA{B: 255, A: 255})
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a big endian TIFF header with a single IFD entry holding the orientation
+	tiff := []byte{'M', 'M', 0, 42, 0, 0, 0, 8, 0, 1, 0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, byte(orientation), 0, 0, 0, 0, 0, 0}
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(segment)+2))
+	app1 = append(app1, segment...)
+
+	data := buf.Bytes()
+	out := append([]byte{}, data[:2]...)
+	out = append(out, app1...)
+	return append(out, data[2:]...)
+}
+
+func TestTools_UploadFilesImageRotateAndStrip(t *testing.T) {
+	data := jpegWithOrientation(t, 40, 20, 6)
+	if exifOrientation(data) != 6 {
+		t.Fatalf("expected test image to have orientation 6, got %d", exifOrientation(data))
+	}
+
+	store := &MemoryStorage{}
+	testTools := Tools{
+		Storage:         store,
+		ImageProcessing: &ImageOptions{AutoRotate: true, StripMetadata: true},
+	}
+
+	uploadedFiles, err := testTools.UploadFiles(multipartRequest(t, testPart{field: "file", fileName: "house.jpg", data: data}), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := uploadedFiles[0]
+	if f.Width != 20 || f.Height != 40 {
+		t.Errorf("expected rotated image to be 20x40, got %dx%d", f.Width, f.Height)
+	}
+
+	rc, _, err := store.Get(context.Background(), f.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := io.ReadAll(rc)
+
+	if bytes.Contains(stored, []byte("Exif")) {
+		t.Error("expected EXIF data to be stripped from the stored image")
+	}
+
+	img, err := jpeg.Decode(bytes.NewReader(stored))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// rotating clockwise moves the red left half to the top
+	if r, _, b, _ := img.At(10, 5).RGBA(); r < b {
+		t.Error("expected the top of the rotated image to be red")
+	}
+}
+
+func TestTools_UploadFilesImageTooLarge(t *testing.T) {
+	testTools := Tools{
+		Storage:         &MemoryStorage{},
+		ImageProcessing: &ImageOptions{MaxWidth: 100, MaxHeight: 100},
+	}
+
+	_, err := testTools.UploadFiles(multipartRequest(t, testPart{field: "file", fileName: "img.png", data: readTestFile(t, "./testdata/img.png")}), "")
+
+	var sizeErr *ImageSizeError
+	if !errors.As(err, &sizeErr) {
+		t.Fatalf("expected *ImageSizeError, got %v", err)
+	}
+	if sizeErr.Width <= 100 && sizeErr.Height <= 100 {
+		t.Errorf("unexpected image size reported: %+v", sizeErr)
+	}
+}
+
+// pngClaimingSize returns a tiny PNG whose header declares it to be width by height pixels
+func pngClaimingSize(t *testing.T, width, height uint32) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+
+	// the IHDR chunk follows the 8 byte signature: its length, type, width and height, then the
+	// rest of its data and a CRC of the type and data
+	data := buf.Bytes()
+	binary.BigEndian.PutUint32(data[16:], width)
+	binary.BigEndian.PutUint32(data[20:], height)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+	return data
+}
+
+func TestTools_UploadFilesImageTooManyPixels(t *testing.T) {
+	testTools := Tools{Storage: &MemoryStorage{}, ImageProcessing: &ImageOptions{}}
+
+	_, err := testTools.UploadFiles(multipartRequest(t, testPart{field: "file", fileName: "bomb.png", data: pngClaimingSize(t, 60000, 60000)}), "")
+
+	var sizeErr *ImageSizeError
+	if !errors.As(err, &sizeErr) || sizeErr.MaxPixels != 50_000_000 {
+		t.Fatalf("expected the default pixel limit to reject the image, got %v", err)
+	}
+
+	testTools.ImageProcessing.MaxPixels = 4
+	_, err = testTools.UploadFiles(multipartRequest(t, testPart{field: "file", fileName: "img.png", data: readTestFile(t, "./testdata/img.png")}), "")
+	if !errors.As(err, &sizeErr) || sizeErr.MaxPixels != 4 {
+		t.Errorf("expected MaxPixels to reject the image, got %v", err)
+	}
+}
+
+var thumbnailTests = []struct {
+	name      string
+	file      string
+	extension string
+}{
+	{name: "jpeg", file: "./testdata/pic.jpg", extension: ".jpg"},
+	{name: "png", file: "./testdata/img.png", extension: ".png"},
+	{name: "webp", file: "./testdata/pic.webp", extension: ".jpg"},
+}
+
+func TestTools_UploadFilesThumbnails(t *testing.T) {
+	for _, e := range thumbnailTests {
+		store := &MemoryStorage{}
+		testTools := Tools{
+			Storage: store,
+			ImageProcessing: &ImageOptions{
+				StripMetadata: true,
+				Thumbnails:    []Thumbnail{{Name: "small", Width: 64, Height: 64}, {Name: "wide", Width: 200}},
+			},
+		}
+
+		uploadedFiles, err := testTools.UploadFiles(multipartRequest(t, testPart{field: "file", fileName: "photo" + e.extension, data: readTestFile(t, e.file)}), "photos")
+		if err != nil {
+			t.Fatalf("%s: %s", e.name, err)
+		}
+
+		f := uploadedFiles[0]
+		if len(f.Variants) != 2 {
+			t.Fatalf("%s: expected 2 variants, got %d", e.name, len(f.Variants))
+		}
+
+		small := f.Variants[0]
+		if small.Name != "small" || small.Width > 64 || small.Height > 64 || small.FileSize == 0 {
+			t.Errorf("%s: unexpected small variant: %+v", e.name, small)
+		}
+		if f.Variants[1].Width != 200 && f.Width > 200 {
+			t.Errorf("%s: expected wide variant to be 200 pixels wide, got %d", e.name, f.Variants[1].Width)
+		}
+
+		if _, err := store.Stat(context.Background(), small.Key); err != nil {
+			t.Errorf("%s: expected variant %s to be stored: %s", e.name, small.Key, err)
+		}
+		if want := thumbnailKey(f.Key, "small", e.extension); small.Key != want {
+			t.Errorf("%s: expected variant key %s, got %s", e.name, want, small.Key)
+		}
+	}
+}
+
+func TestStripWebPMetadata(t *testing.T) {
+	data := readTestFile(t, "./testdata/pic.webp")
+
+	exif := []byte("EXIF\x06\x00\x00\x00GPS!!!")
+	withExif := append(append([]byte{}, data...), exif...)
+	binary.LittleEndian.PutUint32(withExif[4:], uint32(len(withExif)-8))
+
+	stripped, err := stripWebPMetadata(withExif)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(stripped, data) {
+		t.Error("expected the EXIF chunk to be removed")
+	}
+
+	if _, _, err := image.Decode(bytes.NewReader(stripped)); err != nil {
+		t.Errorf("expected stripped image to decode: %s", err)
+	}
+}
@@ -0,0 +1,175 @@
+package gotoolkit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// JSONErrorKind identifies why a JSON body could not be read
+type JSONErrorKind int
+
+const (
+	// JSONSyntax is a body that is not well-formed JSON
+	JSONSyntax JSONErrorKind = iota + 1
+	// JSONTypeMismatch is a JSON value of the wrong type for the field it is decoded into
+	JSONTypeMismatch
+	// JSONUnknownField is a key with no matching field, when Tools.AllowUnknownFields is not set
+	JSONUnknownField
+	// JSONTooLarge is a body larger than Tools.MaxJSONSize
+	JSONTooLarge
+	// JSONEmpty is a body with no content
+	JSONEmpty
+	// JSONTrailingData is a body with more after its first JSON value
+	JSONTrailingData
+	// JSONInvalidTarget is a value, such as a nil pointer, that JSON cannot be decoded into
+	JSONInvalidTarget
+	// JSONUnsupportedMediaType is a body with a Content-Encoding that cannot be decompressed, or
+	// without a JSON Content-Type when Tools.RequireJSONContentType is set
+	JSONUnsupportedMediaType
+	// JSONInvalidEncoding is a compressed body that cannot be decompressed
+	JSONInvalidEncoding
+)
+
+func (k JSONErrorKind) String() string {
+	switch k {
+	case JSONSyntax:
+		return "syntax"
+	case JSONTypeMismatch:
+		return "type mismatch"
+	case JSONUnknownField:
+		return "unknown field"
+	case JSONTooLarge:
+		return "too large"
+	case JSONEmpty:
+		return "empty"
+	case JSONTrailingData:
+		return "trailing data"
+	case JSONInvalidTarget:
+		return "invalid target"
+	case JSONUnsupportedMediaType:
+		return "unsupported media type"
+	case JSONInvalidEncoding:
+		return "invalid encoding"
+	default:
+		return fmt.Sprintf("JSONErrorKind(%d)", int(k))
+	}
+}
+
+// JSONError is returned by ReadJSON when the body cannot be decoded, and by ReadQuery and ReadForm
+// when values cannot be bound. Field is the path of the field, or the name of the parameter, at
+// fault, when known, and Offset the position in the body, in bytes, where the problem was found.
+// Status is the HTTP status code best suited to a response, which ErrorJSON uses when no status
+// is given to it. Err is the underlying error.
+type JSONError struct {
+	Kind   JSONErrorKind
+	Field  string
+	Offset int64
+	Status int
+	Err    error
+
+	// source is "query" or "form" for errors from ReadQuery and ReadForm, whose messages talk
+	// about parameters rather than a JSON body
+	source string
+}
+
+func (e *JSONError) Error() string {
+	if e.source != "" {
+		switch e.Kind {
+		case JSONSyntax:
+			return fmt.Sprintf("%s is malformed: %s", e.source, e.Err)
+		case JSONTypeMismatch:
+			return fmt.Sprintf("%s parameter %q %s", e.source, e.Field, e.Err)
+		case JSONUnknownField:
+			return fmt.Sprintf("%s contains unknown parameter %q", e.source, e.Field)
+		}
+	}
+
+	switch e.Kind {
+	case JSONSyntax:
+		if e.Offset > 0 {
+			return fmt.Sprintf("body contains badly-formed JSON (at character %d)", e.Offset)
+		}
+		return "body contains badly-formed JSON"
+	case JSONTypeMismatch:
+		if e.Field != "" {
+			return fmt.Sprintf("body contains incorrect JSON type for field %q", e.Field)
+		}
+		return fmt.Sprintf("body contains incorrect JSON type (at character %d)", e.Offset)
+	case JSONUnknownField:
+		return fmt.Sprintf("body contains unknown key %q", e.Field)
+	case JSONTooLarge:
+		var maxBytesError *http.MaxBytesError
+		if errors.As(e.Err, &maxBytesError) {
+			return fmt.Sprintf("body must not be larger than %d bytes", maxBytesError.Limit)
+		}
+		if e.Err != nil {
+			return "body is too large: " + e.Err.Error()
+		}
+		return "body is too large"
+	case JSONEmpty:
+		return "body must not be empty"
+	case JSONTrailingData:
+		return "body must contain a single JSON value"
+	case JSONUnsupportedMediaType:
+		return fmt.Sprintf("body has %s", e.Err)
+	case JSONInvalidEncoding:
+		return fmt.Sprintf("body is not valid compressed data: %s", e.Err)
+	default:
+		return fmt.Sprintf("error unmarshalling JSON: %s", e.Err)
+	}
+}
+
+func (e *JSONError) Unwrap() error {
+	return e.Err
+}
+
+// newJSONError turns an error from decoding the first value read by dec into a *JSONError. Errors
+// that are not caused by the body, such as a failure to read it, are returned unchanged.
+func newJSONError(err error, dec *json.Decoder) error {
+	var syntaxError *json.SyntaxError
+	var unmarshalTypeError *json.UnmarshalTypeError
+	var invalidUnmarshalError *json.InvalidUnmarshalError
+	var maxBytesError *http.MaxBytesError
+
+	var jsonErr *JSONError
+	if errors.As(err, &jsonErr) {
+		return jsonErr
+	}
+
+	e := &JSONError{Status: http.StatusBadRequest, Offset: dec.InputOffset(), Err: err}
+
+	switch {
+	case errors.As(err, &maxBytesError):
+		e.Kind, e.Status = JSONTooLarge, http.StatusRequestEntityTooLarge
+
+	case errors.As(err, &syntaxError):
+		e.Kind, e.Offset = JSONSyntax, syntaxError.Offset
+
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		e.Kind = JSONSyntax
+
+	case errors.As(err, &unmarshalTypeError):
+		e.Kind, e.Field, e.Offset = JSONTypeMismatch, unmarshalTypeError.Field, unmarshalTypeError.Offset
+
+	case errors.Is(err, io.EOF):
+		e.Kind = JSONEmpty
+
+	// encoding/json has no error type for unknown fields, so the message is the only way to
+	// recognise them
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		e.Kind = JSONUnknownField
+		e.Field = strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+
+	case errors.As(err, &invalidUnmarshalError):
+		e.Kind, e.Status = JSONInvalidTarget, http.StatusInternalServerError
+
+	default:
+		return err
+	}
+
+	return e
+}
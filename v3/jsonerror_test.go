@@ -0,0 +1,105 @@
+package gotoolkit
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var jsonErrorTests = []struct {
+	name    string
+	json    string
+	kind    JSONErrorKind
+	field   string
+	offset  int64
+	status  int
+	message string
+}{
+	{name: "syntax", json: `{"foo":}`, kind: JSONSyntax, offset: 8, status: http.StatusBadRequest, message: "body contains badly-formed JSON (at character 8)"},
+	{name: "unexpected end", json: `{"foo": "1"`, kind: JSONSyntax, status: http.StatusBadRequest},
+	{name: "type mismatch", json: `{"foo": 1}`, kind: JSONTypeMismatch, field: "foo", offset: 9, status: http.StatusBadRequest, message: `body contains incorrect JSON type for field "foo"`},
+	{name: "nested type mismatch", json: `{"foo": "1", "inner": {"n": "x"}}`, kind: JSONTypeMismatch, field: "inner.n", status: http.StatusBadRequest},
+	{name: "unknown field", json: `{"foo": "1", "bar": 2}`, kind: JSONUnknownField, field: "bar", status: http.StatusBadRequest, message: `body contains unknown key "bar"`},
+	{name: "too large", json: `{"foo": "` + strings.Repeat("a", 100) + `"}`, kind: JSONTooLarge, status: http.StatusRequestEntityTooLarge, message: "body must not be larger than 64 bytes"},
+	{name: "empty", json: ``, kind: JSONEmpty, status: http.StatusBadRequest, message: "body must not be empty"},
+	{name: "trailing data", json: `{"foo": "1"} {"foo": "2"}`, kind: JSONTrailingData, offset: 12, status: http.StatusBadRequest, message: "body must contain a single JSON value"},
+}
+
+func TestTools_ReadJSONErrors(t *testing.T) {
+	testTools := Tools{MaxJSONSize: 64}
+
+	for _, e := range jsonErrorTests {
+		var data struct {
+			Foo   string `json:"foo"`
+			Inner struct {
+				N int `json:"n"`
+			} `json:"inner"`
+		}
+
+		req := httptest.NewRequest("POST", "/", strings.NewReader(e.json))
+		err := testTools.ReadJSON(httptest.NewRecorder(), req, &data)
+
+		var jsonErr *JSONError
+		if !errors.As(err, &jsonErr) {
+			t.Errorf("%s: expected a *JSONError, got %v", e.name, err)
+			continue
+		}
+		if jsonErr.Kind != e.kind || jsonErr.Field != e.field || jsonErr.Status != e.status {
+			t.Errorf("%s: expected %s %q %d, got %s %q %d", e.name, e.kind, e.field, e.status, jsonErr.Kind, jsonErr.Field, jsonErr.Status)
+		}
+		if e.offset != 0 && jsonErr.Offset != e.offset {
+			t.Errorf("%s: expected offset %d, got %d", e.name, e.offset, jsonErr.Offset)
+		}
+		if e.message != "" && err.Error() != e.message {
+			t.Errorf("%s: expected message %q, got %q", e.name, e.message, err.Error())
+		}
+	}
+}
+
+func TestTools_ReadJSONInvalidTarget(t *testing.T) {
+	var testTools Tools
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{"foo": "1"}`))
+	err := testTools.ReadJSON(httptest.NewRecorder(), req, nil)
+
+	var jsonErr *JSONError
+	if !errors.As(err, &jsonErr) || jsonErr.Kind != JSONInvalidTarget || jsonErr.Status != http.StatusInternalServerError {
+		t.Errorf("expected an invalid target error, got %v", err)
+	}
+}
+
+func TestTools_ErrorJSONStatus(t *testing.T) {
+	var testTools Tools
+
+	var tests = []struct {
+		name   string
+		err    error
+		status []int
+		code   int
+	}{
+		{name: "plain error", err: errors.New("some error"), code: http.StatusBadRequest},
+		{name: "json error", err: &JSONError{Kind: JSONTooLarge, Status: http.StatusRequestEntityTooLarge}, code: http.StatusRequestEntityTooLarge},
+		{name: "explicit status", err: &JSONError{Kind: JSONTooLarge, Status: http.StatusRequestEntityTooLarge}, status: []int{http.StatusTeapot}, code: http.StatusTeapot},
+		{name: "json error without status", err: &JSONError{Kind: JSONSyntax}, code: http.StatusBadRequest},
+		{name: "decode error without status", err: &DecodeError{ContentType: "application/xml"}, code: http.StatusBadRequest},
+		{name: "patch error without status", err: &PatchError{Kind: PatchInvalidPath}, code: http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		rr := httptest.NewRecorder()
+		if err := testTools.ErrorJSON(rr, e.err, e.status...); err != nil {
+			t.Fatal(err)
+		}
+
+		var payload JSONResponse
+		if err := json.NewDecoder(rr.Body).Decode(&payload); err != nil {
+			t.Fatal(err)
+		}
+		if rr.Code != e.code || !payload.Error {
+			t.Errorf("%s: expected status %d, got %d", e.name, e.code, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package gotoolkit
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrNotAcceptable is returned by Write when the client accepts none of the available formats
+var ErrNotAcceptable = errors.New("none of the available response formats is acceptable")
+
+// Write sends data to the client in the format it prefers, chosen from the Accept header of r
+// using q-values. JSON, XML, MessagePack and CBOR are built in, and t.Codecs can add more. If no
+// format is acceptable a 406 Not Acceptable response listing the available formats is sent, and
+// ErrNotAcceptable returned.
+func (t *Tools) Write(w http.ResponseWriter, r *http.Request, status int, data any, headers ...http.Header) error {
+	types, codecs := t.codecs()
+
+	w.Header().Add("Vary", "Accept")
+
+	mediaType := negotiate(r.Header.Get("Accept"), types)
+	if mediaType == "" {
+		http.Error(w, "Not Acceptable; available formats: "+strings.Join(types, ", "), http.StatusNotAcceptable)
+		return ErrNotAcceptable
+	}
+
+	var out bytes.Buffer
+	if err := codecs[mediaType].Encode(&out, data); err != nil {
+		return err
+	}
+
+	return t.send(w, status, mediaType, out.Bytes(), headers...)
+}
+
+// Read decodes the body of r into data, choosing the format from its Content-Type header. A body
+// without a Content-Type is read as JSON. The rules of ReadJSON apply to every format: the body
+// may not be larger than MaxJSONSize, may be compressed, must hold a single value, may only
+// contain unknown fields if AllowUnknownFields is set, and is validated if ValidateJSON is set.
+// JSON bodies return the same errors as ReadJSON; other formats return a *DecodeError.
+func (t *Tools) Read(w http.ResponseWriter, r *http.Request, data any) error {
+	mediaType := "application/json"
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		var err error
+		mediaType, _, err = mime.ParseMediaType(contentType)
+		if err != nil {
+			return &DecodeError{ContentType: contentType, Status: http.StatusUnsupportedMediaType, Err: err}
+		}
+	}
+
+	body, err := t.requestBody(w, r)
+	if err != nil {
+		return err
+	}
+
+	_, custom := t.Codecs[mediaType]
+	if !custom && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")) {
+		return t.decodeJSON(body, data)
+	}
+
+	_, codecs := t.codecs()
+	codec, ok := codecs[mediaType]
+	if !ok {
+		return &DecodeError{ContentType: mediaType, Status: http.StatusUnsupportedMediaType, Err: errors.New("unsupported content type")}
+	}
+
+	raw, err := io.ReadAll(body)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			return &DecodeError{ContentType: mediaType, Status: http.StatusRequestEntityTooLarge, Err: err}
+		}
+		return err
+	}
+
+	if err := codec.Decode(raw, data, !t.AllowUnknownFields); err != nil {
+		return &DecodeError{ContentType: mediaType, Status: http.StatusBadRequest, Err: err}
+	}
+
+	if t.ValidateJSON {
+		return t.Validate(data)
+	}
+
+	return nil
+}
+
+// codecs returns the media types that can be written, in order of preference, and the codec for
+// each. The built-in formats come first, followed by those added in t.Codecs in alphabetical order.
+func (t *Tools) codecs() ([]string, map[string]Codec) {
+	types := make([]string, 0, len(builtinCodecs)+len(t.Codecs))
+	codecs := make(map[string]Codec, len(builtinCodecs)+len(t.Codecs))
+
+	for _, b := range builtinCodecs {
+		types = append(types, b.mediaType)
+		codecs[b.mediaType] = b.codec
+	}
+
+	custom := make([]string, 0, len(t.Codecs))
+	for mediaType := range t.Codecs {
+		custom = append(custom, mediaType)
+	}
+	sort.Strings(custom)
+
+	for _, mediaType := range custom {
+		if _, ok := codecs[mediaType]; !ok {
+			types = append(types, mediaType)
+		}
+		codecs[mediaType] = t.Codecs[mediaType]
+	}
+
+	return types, codecs
+}
+
+// acceptRange is one media range from an Accept header
+type acceptRange struct {
+	typ, subtype string
+	q            float64
+}
+
+// negotiate returns the type from types that best matches the Accept header accept, or "" if
+// none is acceptable. A type takes the q-value of the most specific range that matches it; ties
+// go to the type whose range came first in the header, and then to the order of types.
+func negotiate(accept string, types []string) string {
+	var ranges []acceptRange
+	for _, part := range strings.Split(accept, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		if mediaType == "*" {
+			mediaType = "*/*"
+		}
+		typ, subtype, ok := strings.Cut(mediaType, "/")
+		if !ok {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil || q < 0 || q > 1 {
+				continue
+			}
+		}
+
+		ranges = append(ranges, acceptRange{typ: typ, subtype: subtype, q: q})
+	}
+
+	if len(ranges) == 0 {
+		if strings.TrimSpace(accept) != "" {
+			return ""
+		}
+		return types[0]
+	}
+
+	best, bestQ, bestIndex := "", 0.0, len(ranges)
+	for _, mediaType := range types {
+		typ, subtype, _ := strings.Cut(mediaType, "/")
+
+		q, index, specificity := 0.0, -1, -1
+		for i, r := range ranges {
+			s := -1
+			switch {
+			case r.typ == typ && r.subtype == subtype:
+				s = 2
+			case r.typ == typ && r.subtype == "*":
+				s = 1
+			case r.typ == "*" && r.subtype == "*":
+				s = 0
+			}
+			if s > specificity {
+				q, index, specificity = r.q, i, s
+			}
+		}
+
+		if index >= 0 && q > 0 && (q > bestQ || (q == bestQ && index < bestIndex)) {
+			best, bestQ, bestIndex = mediaType, q, index
+		}
+	}
+
+	return best
+}
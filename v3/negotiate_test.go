@@ -0,0 +1,203 @@
+package gotoolkit
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+var negotiateTests = []struct {
+	name     string
+	accept   string
+	expected string
+}{
+	{name: "no header", accept: "", expected: "application/json"},
+	{name: "anything", accept: "*/*", expected: "application/json"},
+	{name: "exact", accept: "application/xml", expected: "application/xml"},
+	{name: "client order", accept: "application/cbor, application/json", expected: "application/cbor"},
+	{name: "q values", accept: "application/json;q=0.5, application/msgpack", expected: "application/msgpack"},
+	{name: "type wildcard", accept: "text/*", expected: "text/xml"},
+	{name: "browser", accept: "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", expected: "application/xml"},
+	{name: "excluded", accept: "application/json;q=0, */*;q=0.1", expected: "application/xml"},
+	{name: "nothing matches", accept: "text/html, image/png", expected: ""},
+	{name: "all excluded", accept: "*/*;q=0", expected: ""},
+	{name: "malformed", accept: "garbage", expected: ""},
+}
+
+func TestNegotiate(t *testing.T) {
+	var testTools Tools
+	types, _ := testTools.codecs()
+
+	for _, e := range negotiateTests {
+		if got := negotiate(e.accept, types); got != e.expected {
+			t.Errorf("%s: expected %q, got %q", e.name, e.expected, got)
+		}
+	}
+}
+
+type testPayload struct {
+	XMLName xml.Name `json:"-" xml:"payload"`
+	Name    string   `json:"name" xml:"name"`
+	Rooms   int      `json:"rooms" xml:"rooms"`
+}
+
+func TestTools_Write(t *testing.T) {
+	var testTools Tools
+	data := testPayload{Name: "Sea view", Rooms: 3}
+
+	decoders := map[string]func([]byte, any) error{
+		"application/json": func(b []byte, v any) error { return jsonCodec{}.Decode(b, v, true) },
+		"application/xml":  xml.Unmarshal,
+		"application/msgpack": func(b []byte, v any) error {
+			dec := msgpack.NewDecoder(bytes.NewReader(b))
+			dec.SetCustomStructTag("json")
+			return dec.Decode(v)
+		},
+		"application/cbor": cbor.Unmarshal,
+	}
+
+	for mediaType, decode := range decoders {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept", mediaType)
+		rr := httptest.NewRecorder()
+
+		if err := testTools.Write(rr, req, http.StatusCreated, data, http.Header{"X-Test": {"1"}}); err != nil {
+			t.Fatal(err)
+		}
+		if rr.Code != http.StatusCreated || rr.Header().Get("Content-Type") != mediaType || rr.Header().Get("X-Test") != "1" || rr.Header().Get("Vary") != "Accept" {
+			t.Errorf("%s: unexpected response %d %v", mediaType, rr.Code, rr.Header())
+		}
+
+		var got testPayload
+		if err := decode(rr.Body.Bytes(), &got); err != nil {
+			t.Fatalf("%s: %s", mediaType, err)
+		}
+		got.XMLName = xml.Name{}
+		if got != data {
+			t.Errorf("%s: expected %+v, got %+v", mediaType, data, got)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept", "text/html")
+	rr := httptest.NewRecorder()
+	if err := testTools.Write(rr, req, http.StatusOK, data); !errors.Is(err, ErrNotAcceptable) {
+		t.Errorf("expected ErrNotAcceptable, got %v", err)
+	}
+	if rr.Code != http.StatusNotAcceptable || !strings.Contains(rr.Body.String(), "application/msgpack") {
+		t.Errorf("unexpected 406 response %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+// upperCodec is a toy format used to test Tools.Codecs
+type upperCodec struct{}
+
+func (upperCodec) Encode(w io.Writer, v any) error {
+	_, err := io.WriteString(w, strings.ToUpper(v.(string)))
+	return err
+}
+
+func (upperCodec) Decode(data []byte, v any, strict bool) error {
+	*v.(*string) = strings.ToLower(string(data))
+	return nil
+}
+
+func TestTools_WriteReadCustomCodec(t *testing.T) {
+	testTools := Tools{Codecs: map[string]Codec{"text/x-upper": upperCodec{}}}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept", "text/x-upper, application/json;q=0.5")
+	rr := httptest.NewRecorder()
+	if err := testTools.Write(rr, req, http.StatusOK, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Body.String() != "HELLO" || rr.Header().Get("Content-Type") != "text/x-upper" {
+		t.Errorf("unexpected response %s %v", rr.Body.String(), rr.Header())
+	}
+
+	req = httptest.NewRequest("POST", "/", strings.NewReader("HELLO"))
+	req.Header.Set("Content-Type", "text/x-upper; charset=utf-8")
+	var got string
+	if err := testTools.Read(httptest.NewRecorder(), req, &got); err != nil || got != "hello" {
+		t.Errorf("expected hello, got %q %v", got, err)
+	}
+}
+
+func encodeWith(t *testing.T, c Codec, v any) []byte {
+	t.Helper()
+
+	var b bytes.Buffer
+	if err := c.Encode(&b, v); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestTools_Read(t *testing.T) {
+	type extended struct {
+		Name  string `json:"name" xml:"name"`
+		Rooms int    `json:"rooms" xml:"rooms"`
+		Extra bool   `json:"extra" xml:"extra"`
+	}
+	valid := testPayload{Name: "Sea view", Rooms: 3}
+	unknown := extended{Name: "Sea view", Rooms: 3, Extra: true}
+
+	var tests = []struct {
+		name        string
+		contentType string
+		body        []byte
+		status      int
+	}{
+		{name: "json", contentType: "application/json", body: []byte(`{"name": "Sea view", "rooms": 3}`)},
+		{name: "no content type", body: []byte(`{"name": "Sea view", "rooms": 3}`)},
+		{name: "json suffix", contentType: "application/merge-patch+json; charset=utf-8", body: []byte(`{"name": "Sea view", "rooms": 3}`)},
+		{name: "xml", contentType: "application/xml", body: []byte(`<payload><name>Sea view</name><rooms>3</rooms></payload>`)},
+		{name: "msgpack", contentType: "application/msgpack", body: encodeWith(t, msgpackCodec{}, valid)},
+		{name: "cbor", contentType: "application/cbor", body: encodeWith(t, cborCodec{}, valid)},
+		{name: "json unknown field", contentType: "application/json", body: []byte(`{"name": "Sea view", "rooms": 3, "extra": true}`), status: http.StatusBadRequest},
+		{name: "msgpack unknown field", contentType: "application/msgpack", body: encodeWith(t, msgpackCodec{}, unknown), status: http.StatusBadRequest},
+		{name: "cbor unknown field", contentType: "application/cbor", body: encodeWith(t, cborCodec{}, unknown), status: http.StatusBadRequest},
+		{name: "xml trailing data", contentType: "application/xml", body: []byte(`<payload><name>a</name></payload><payload></payload>`), status: http.StatusBadRequest},
+		{name: "msgpack trailing data", contentType: "application/msgpack", body: append(encodeWith(t, msgpackCodec{}, valid), 0x01), status: http.StatusBadRequest},
+		{name: "cbor trailing data", contentType: "application/cbor", body: append(encodeWith(t, cborCodec{}, valid), 0x01), status: http.StatusBadRequest},
+		{name: "xml too large", contentType: "application/xml", body: []byte(`<payload><name>` + strings.Repeat("a", 200) + `</name></payload>`), status: http.StatusRequestEntityTooLarge},
+		{name: "json too large", contentType: "application/json", body: []byte(`{"name": "` + strings.Repeat("a", 200) + `"}`), status: http.StatusRequestEntityTooLarge},
+		{name: "unsupported", contentType: "text/csv", body: []byte("name,rooms"), status: http.StatusUnsupportedMediaType},
+		{name: "malformed content type", contentType: "application/", body: []byte("x"), status: http.StatusUnsupportedMediaType},
+	}
+
+	testTools := Tools{MaxJSONSize: 128}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("POST", "/", bytes.NewReader(e.body))
+		if e.contentType != "" {
+			req.Header.Set("Content-Type", e.contentType)
+		}
+
+		var got testPayload
+		err := testTools.Read(httptest.NewRecorder(), req, &got)
+
+		if e.status == 0 {
+			got.XMLName = xml.Name{}
+			if err != nil || !reflect.DeepEqual(got, valid) {
+				t.Errorf("%s: expected %+v, got %+v %v", e.name, valid, got, err)
+			}
+			continue
+		}
+
+		rr := httptest.NewRecorder()
+		_ = testTools.ErrorJSON(rr, err)
+		if err == nil || rr.Code != e.status {
+			t.Errorf("%s: expected status %d, got %d (%v)", e.name, e.status, rr.Code, err)
+		}
+	}
+}
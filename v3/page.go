@@ -0,0 +1,216 @@
+package gotoolkit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidCursor is returned, inside a *JSONError, by DecodeCursor for a cursor that was not
+// made by EncodeCursor, or has been changed
+var ErrInvalidCursor = errors.New("must be a cursor returned with a previous page")
+
+// Response is JSONResponse with a typed Data field, so that clients can see the shape of the
+// data. It is sent as the same JSON.
+type Response[T any] struct {
+	Error   bool              `json:"error"`
+	Message string            `json:"message"`
+	Data    T                 `json:"data,omitempty"`
+	Errors  map[string]string `json:"errors,omitempty"`
+}
+
+// Page is one page of a list. Offset and Total are used for offset paging, and NextCursor for
+// cursor paging, where it is empty on the last page.
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	Limit      int    `json:"limit"`
+	Offset     int    `json:"offset,omitempty"`
+	Total      int    `json:"total,omitempty"`
+	NextCursor string `json:"next_cursor,omitempty"`
+
+	cursor bool
+}
+
+// PageParams are the paging parameters of a request, read by ReadPageParams
+type PageParams struct {
+	Limit  int
+	Offset int
+	Cursor string
+}
+
+// ReadPageParams reads the limit, offset and cursor query parameters of r. Limit defaults to
+// t.DefaultPageSize, or 20, and is capped at t.MaxPageSize, or 100. Invalid values are returned
+// as a *JSONError, as ReadQuery does.
+func (t *Tools) ReadPageParams(r *http.Request) (PageParams, error) {
+	defaultLimit := 20
+	if t.DefaultPageSize != 0 {
+		defaultLimit = t.DefaultPageSize
+	}
+	maxLimit := 100
+	if t.MaxPageSize != 0 {
+		maxLimit = t.MaxPageSize
+	}
+
+	q := r.URL.Query()
+	params := PageParams{Limit: defaultLimit, Cursor: q.Get("cursor")}
+
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return PageParams{}, pageParamError("limit", "must be a positive integer")
+		}
+		params.Limit = n
+	}
+	if params.Limit > maxLimit {
+		params.Limit = maxLimit
+	}
+
+	if s := q.Get("offset"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return PageParams{}, pageParamError("offset", "must be a non-negative integer")
+		}
+		params.Offset = n
+	}
+
+	return params, nil
+}
+
+func pageParamError(field, message string) error {
+	return &JSONError{Kind: JSONTypeMismatch, Field: field, Status: http.StatusBadRequest, Err: errors.New(message), source: "query"}
+}
+
+// EncodeCursor returns an opaque cursor token holding keys, usually the sort keys of the last
+// item on a page, as base64 encoded JSON. When t.SigningKey is set the token is signed, so that
+// clients cannot make up their own.
+func (t *Tools) EncodeCursor(keys any) (string, error) {
+	out, err := json.Marshal(keys)
+	if err != nil {
+		return "", err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(out)
+	if len(t.SigningKey) > 0 {
+		token += "." + t.cursorSignature(token)
+	}
+	return token, nil
+}
+
+// DecodeCursor decodes a token made by EncodeCursor into keys. It returns a *JSONError wrapping
+// ErrInvalidCursor if the token is malformed or its signature does not match.
+func (t *Tools) DecodeCursor(token string, keys any) error {
+	invalid := &JSONError{Kind: JSONTypeMismatch, Field: "cursor", Status: http.StatusBadRequest, Err: ErrInvalidCursor, source: "query"}
+
+	payload, signature, signed := strings.Cut(token, ".")
+	if len(t.SigningKey) > 0 {
+		if !signed || !hmac.Equal([]byte(signature), []byte(t.cursorSignature(payload))) {
+			return invalid
+		}
+	} else if signed {
+		return invalid
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return invalid
+	}
+	if err := json.Unmarshal(data, keys); err != nil {
+		return invalid
+	}
+
+	return nil
+}
+
+// cursorSignature signs the payload of a cursor. Link signatures always hold two newlines, so
+// neither kind of signature can be used as the other.
+func (t *Tools) cursorSignature(payload string) string {
+	mac := hmac.New(sha256.New, t.SigningKey)
+	mac.Write([]byte("cursor\n" + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// NewOffsetPage returns the page of items found at params.Offset, out of total items, or 0 if the
+// total is not known
+func NewOffsetPage[T any](items []T, params PageParams, total int) Page[T] {
+	return Page[T]{Items: items, Limit: params.Limit, Offset: params.Offset, Total: total}
+}
+
+// NewCursorPage returns a page of items for cursor paging. items should be fetched with a limit of
+// params.Limit+1: if there is an extra item it is dropped, and NextCursor is set to the cursor of
+// the sort keys that key returns for the last item kept. params.Limit must be at least 1, as it is
+// when read by ReadPageParams.
+func NewCursorPage[T any](t *Tools, items []T, params PageParams, key func(item T) any) (Page[T], error) {
+	if params.Limit < 1 {
+		return Page[T]{}, errors.New("page limit must be at least 1")
+	}
+
+	page := Page[T]{Items: items, Limit: params.Limit, cursor: true}
+
+	if len(items) > params.Limit {
+		page.Items = items[:params.Limit]
+		cursor, err := t.EncodeCursor(key(page.Items[len(page.Items)-1]))
+		if err != nil {
+			return Page[T]{}, err
+		}
+		page.NextCursor = cursor
+	}
+
+	return page, nil
+}
+
+// SetPageLinks adds a Link header to w with the first, previous, next and last pages around
+// page, as far as they are known. The links are the URL of r with its paging parameters
+// changed.
+func SetPageLinks[T any](w http.ResponseWriter, r *http.Request, page Page[T]) {
+	link := func(rel string, set map[string]string) string {
+		u := *r.URL
+		q := u.Query()
+		for _, key := range []string{"cursor", "offset"} {
+			q.Del(key)
+		}
+		q.Set("limit", strconv.Itoa(page.Limit))
+		for key, value := range set {
+			q.Set(key, value)
+		}
+		u.RawQuery = q.Encode()
+		return fmt.Sprintf(`<%s>; rel="%s"`, u.RequestURI(), rel)
+	}
+
+	links := []string{link("first", nil)}
+
+	if page.cursor || page.NextCursor != "" {
+		if page.NextCursor != "" {
+			links = append(links, link("next", map[string]string{"cursor": page.NextCursor}))
+		}
+	} else if page.Limit > 0 {
+		if page.Offset > 0 {
+			prev := page.Offset - page.Limit
+			if prev < 0 {
+				prev = 0
+			}
+			links = append(links, link("prev", map[string]string{"offset": strconv.Itoa(prev)}))
+		}
+		next := page.Offset + len(page.Items)
+		if (page.Total > 0 && next < page.Total) || (page.Total == 0 && len(page.Items) == page.Limit) {
+			links = append(links, link("next", map[string]string{"offset": strconv.Itoa(next)}))
+		}
+		if page.Total > 0 {
+			last := (page.Total - 1) / page.Limit * page.Limit
+			links = append(links, link("last", map[string]string{"offset": strconv.Itoa(last)}))
+		}
+	}
+
+	w.Header().Set("Link", strings.Join(links, ", "))
+}
+
+// WritePage sends page as the Data of a Response, with a Link header from SetPageLinks
+func WritePage[T any](t *Tools, w http.ResponseWriter, r *http.Request, status int, page Page[T], headers ...http.Header) error {
+	SetPageLinks(w, r, page)
+	return t.WriteJSON(w, status, Response[Page[T]]{Data: page}, headers...)
+}
@@ -0,0 +1,197 @@
+package gotoolkit
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var pageParamsTests = []struct {
+	name     string
+	query    string
+	expected PageParams
+	field    string
+}{
+	{name: "defaults", query: "", expected: PageParams{Limit: 20}},
+	{name: "offset", query: "limit=10&offset=30", expected: PageParams{Limit: 10, Offset: 30}},
+	{name: "cursor", query: "limit=5&cursor=abc", expected: PageParams{Limit: 5, Cursor: "abc"}},
+	{name: "capped", query: "limit=1000", expected: PageParams{Limit: 100}},
+	{name: "zero limit", query: "limit=0", field: "limit"},
+	{name: "bad limit", query: "limit=ten", field: "limit"},
+	{name: "negative offset", query: "offset=-1", field: "offset"},
+}
+
+func TestTools_ReadPageParams(t *testing.T) {
+	var testTools Tools
+
+	for _, e := range pageParamsTests {
+		req := httptest.NewRequest("GET", "/listings?"+e.query, nil)
+		params, err := testTools.ReadPageParams(req)
+
+		if e.field == "" {
+			if err != nil || params != e.expected {
+				t.Errorf("%s: expected %+v, got %+v %v", e.name, e.expected, params, err)
+			}
+			continue
+		}
+
+		var jsonErr *JSONError
+		if !errors.As(err, &jsonErr) || jsonErr.Field != e.field || jsonErr.Status != http.StatusBadRequest {
+			t.Errorf("%s: expected an error for %s, got %v", e.name, e.field, err)
+		}
+	}
+
+	testTools = Tools{DefaultPageSize: 5, MaxPageSize: 8}
+	params, _ := testTools.ReadPageParams(httptest.NewRequest("GET", "/", nil))
+	capped, _ := testTools.ReadPageParams(httptest.NewRequest("GET", "/?limit=9", nil))
+	if params.Limit != 5 || capped.Limit != 8 {
+		t.Errorf("expected limits 5 and 8, got %d and %d", params.Limit, capped.Limit)
+	}
+}
+
+type testCursorKeys struct {
+	Price int `json:"p"`
+	ID    int `json:"id"`
+}
+
+func TestTools_Cursors(t *testing.T) {
+	signed := Tools{SigningKey: []byte("a very secret key for signing it")}
+	unsigned := Tools{}
+
+	for _, testTools := range []Tools{signed, unsigned} {
+		token, err := testTools.EncodeCursor(testCursorKeys{Price: 100, ID: 7})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.ContainsAny(token, "+/=") {
+			t.Errorf("cursor %q is not URL safe", token)
+		}
+
+		var keys testCursorKeys
+		if err := testTools.DecodeCursor(token, &keys); err != nil || keys != (testCursorKeys{Price: 100, ID: 7}) {
+			t.Errorf("expected the keys back, got %+v %v", keys, err)
+		}
+	}
+
+	token, _ := signed.EncodeCursor(testCursorKeys{Price: 100, ID: 7})
+	forged, _ := unsigned.EncodeCursor(testCursorKeys{Price: 1, ID: 7})
+	payload, signature, _ := strings.Cut(token, ".")
+
+	for _, bad := range []string{forged, forged + "." + signature, payload + ".x", "!!!", payload[:len(payload)-2] + "." + signature} {
+		var keys testCursorKeys
+		err := signed.DecodeCursor(bad, &keys)
+		var jsonErr *JSONError
+		if !errors.Is(err, ErrInvalidCursor) || !errors.As(err, &jsonErr) || jsonErr.Field != "cursor" {
+			t.Errorf("expected %q to be rejected, got %v", bad, err)
+		}
+	}
+
+	if err := unsigned.DecodeCursor(token, new(testCursorKeys)); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("a signed cursor should be rejected without the key, got %v", err)
+	}
+}
+
+func TestNewCursorPage(t *testing.T) {
+	var testTools Tools
+	params := PageParams{Limit: 2}
+	key := func(item testCursorKeys) any { return item }
+
+	page, err := NewCursorPage(&testTools, []testCursorKeys{{1, 1}, {2, 2}, {3, 3}}, params, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Items) != 2 || page.NextCursor == "" {
+		t.Fatalf("expected two items and a next cursor, got %+v", page)
+	}
+
+	var keys testCursorKeys
+	if err := testTools.DecodeCursor(page.NextCursor, &keys); err != nil || keys != (testCursorKeys{2, 2}) {
+		t.Errorf("expected the cursor of the last item, got %+v %v", keys, err)
+	}
+
+	last, _ := NewCursorPage(&testTools, []testCursorKeys{{3, 3}}, params, key)
+	if last.NextCursor != "" {
+		t.Error("the last page should have no next cursor")
+	}
+
+	if _, err := NewCursorPage(&testTools, []testCursorKeys{{1, 1}}, PageParams{}, key); err == nil {
+		t.Error("expected an error for a page without a limit")
+	}
+}
+
+var pageLinksTests = []struct {
+	name     string
+	page     Page[int]
+	expected string
+}{
+	{
+		name:     "first offset page",
+		page:     Page[int]{Items: []int{1, 2}, Limit: 2, Total: 5},
+		expected: `</listings?limit=2&q=sea>; rel="first", </listings?limit=2&offset=2&q=sea>; rel="next", </listings?limit=2&offset=4&q=sea>; rel="last"`,
+	},
+	{
+		name:     "middle offset page",
+		page:     Page[int]{Items: []int{3, 4}, Limit: 2, Offset: 2, Total: 5},
+		expected: `</listings?limit=2&q=sea>; rel="first", </listings?limit=2&offset=0&q=sea>; rel="prev", </listings?limit=2&offset=4&q=sea>; rel="next", </listings?limit=2&offset=4&q=sea>; rel="last"`,
+	},
+	{
+		name:     "last page of unknown total",
+		page:     Page[int]{Items: []int{5}, Limit: 2, Offset: 4},
+		expected: `</listings?limit=2&q=sea>; rel="first", </listings?limit=2&offset=2&q=sea>; rel="prev"`,
+	},
+	{
+		name:     "cursor page",
+		page:     Page[int]{Items: []int{1, 2}, Limit: 2, NextCursor: "abc"},
+		expected: `</listings?limit=2&q=sea>; rel="first", </listings?cursor=abc&limit=2&q=sea>; rel="next"`,
+	},
+	{
+		name:     "last cursor page",
+		page:     Page[int]{Items: []int{1, 2}, Limit: 2, cursor: true},
+		expected: `</listings?limit=2&q=sea>; rel="first"`,
+	},
+}
+
+func TestSetPageLinks(t *testing.T) {
+	for _, e := range pageLinksTests {
+		req := httptest.NewRequest("GET", "/listings?q=sea&offset=2&cursor=old", nil)
+		rr := httptest.NewRecorder()
+		SetPageLinks(rr, req, e.page)
+
+		if got := rr.Header().Get("Link"); got != e.expected {
+			t.Errorf("%s: expected\n%s\ngot\n%s", e.name, e.expected, got)
+		}
+	}
+}
+
+func TestWritePage(t *testing.T) {
+	var testTools Tools
+
+	req := httptest.NewRequest("GET", "/listings", nil)
+	rr := httptest.NewRecorder()
+	page := NewOffsetPage([]string{"a", "b"}, PageParams{Limit: 2}, 3)
+	if err := WritePage(&testTools, rr, req, http.StatusOK, page); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(rr.Header().Get("Link"), `rel="next"`) {
+		t.Errorf("expected a next link, got %q", rr.Header().Get("Link"))
+	}
+
+	var got Response[Page[string]]
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Error || !reflect.DeepEqual(got.Data.Items, []string{"a", "b"}) || got.Data.Total != 3 {
+		t.Errorf("unexpected response %s", rr.Body.String())
+	}
+
+	// a Response is sent as the same JSON as a JSONResponse
+	var legacy JSONResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &legacy); err != nil || legacy.Data == nil {
+		t.Errorf("expected a JSONResponse, got %+v %v", legacy, err)
+	}
+}
@@ -0,0 +1,634 @@
+package gotoolkit
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// PatchErrorKind identifies why a patch could not be applied
+type PatchErrorKind int
+
+const (
+	// PatchInvalidOperation is an operation that is malformed, such as one with an unknown op or
+	// without a required member
+	PatchInvalidOperation PatchErrorKind = iota + 1
+	// PatchInvalidPath is a path that is not a valid JSON Pointer
+	PatchInvalidPath
+	// PatchPathNotFound is a path that does not exist in the document
+	PatchPathNotFound
+	// PatchTestFailed is a test operation whose value did not match
+	PatchTestFailed
+	// PatchForbiddenPath is a path that is not in the list of patchable paths
+	PatchForbiddenPath
+	// PatchInvalidResult is a patched document that cannot be decoded into the target
+	PatchInvalidResult
+)
+
+func (k PatchErrorKind) String() string {
+	switch k {
+	case PatchInvalidOperation:
+		return "invalid operation"
+	case PatchInvalidPath:
+		return "invalid path"
+	case PatchPathNotFound:
+		return "path not found"
+	case PatchTestFailed:
+		return "test failed"
+	case PatchForbiddenPath:
+		return "forbidden path"
+	case PatchInvalidResult:
+		return "invalid result"
+	default:
+		return fmt.Sprintf("PatchErrorKind(%d)", int(k))
+	}
+}
+
+// PatchError is returned by ReadJSONPatch and ReadMergePatch when a patch cannot be applied.
+// Index is the position of the failing operation in a JSON Patch, or -1 when the error is not
+// caused by a single operation, and Path the JSON Pointer at fault. Status is the HTTP status
+// code best suited to a response, which ErrorJSON uses when no status is given to it.
+type PatchError struct {
+	Kind   PatchErrorKind
+	Index  int
+	Op     string
+	Path   string
+	Status int
+	Err    error
+}
+
+func (e *PatchError) Error() string {
+	var msg string
+	switch e.Kind {
+	case PatchInvalidPath:
+		msg = fmt.Sprintf("invalid path %q: %s", e.Path, e.Err)
+	case PatchPathNotFound:
+		msg = fmt.Sprintf("path %q does not exist", e.Path)
+	case PatchTestFailed:
+		msg = fmt.Sprintf("test of %q failed", e.Path)
+	case PatchForbiddenPath:
+		msg = fmt.Sprintf("path %q may not be changed", e.Path)
+	case PatchInvalidResult:
+		msg = fmt.Sprintf("patched document is not valid: %s", e.Err)
+	default:
+		msg = fmt.Sprint(e.Err)
+	}
+
+	if e.Index < 0 {
+		return msg
+	}
+	return fmt.Sprintf("patch operation %d: %s", e.Index, msg)
+}
+
+func (e *PatchError) Unwrap() error {
+	return e.Err
+}
+
+// PatchOperation is one operation of an RFC 6902 JSON Patch
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// ReadJSONPatch reads an RFC 6902 JSON Patch from the body of r, under the same limits as
+// ReadJSON, and applies it to target, which must be a non-nil pointer. target may hold any Go
+// value, or a json.RawMessage to patch a raw document. When allowed is given, only the paths it
+// lists, and anything below them, may be changed; a "*" segment matches any member or index, as
+// in "/photos/*/caption". The operations are applied to the JSON form of target, which is only
+// replaced once they have all succeeded. Fields that are not part of that form, such as those
+// tagged `json:"-"`, are carried over, including those of nested structs; structs inside slices
+// and maps, or with their own JSON encoding, are replaced whole. Problems with the patch are returned as a *PatchError, and when ValidateJSON is set the
+// patched value is validated before target is replaced.
+func (t *Tools) ReadJSONPatch(w http.ResponseWriter, r *http.Request, target any, allowed ...string) error {
+	// each operation is decoded on its own, since RFC 6902 says unknown members must be ignored
+	var raw []json.RawMessage
+	if err := t.ReadJSON(w, r, &raw); err != nil {
+		return err
+	}
+
+	ops := make([]PatchOperation, len(raw))
+	for i, op := range raw {
+		if err := json.Unmarshal(op, &ops[i]); err != nil {
+			return &PatchError{Kind: PatchInvalidOperation, Index: i, Status: http.StatusBadRequest, Err: err}
+		}
+	}
+
+	return t.patch(target, func(doc any) (any, error) {
+		return applyJSONPatch(doc, ops, allowed)
+	})
+}
+
+// ReadMergePatch reads an RFC 7396 JSON Merge Patch from the body of r, under the same limits as
+// ReadJSON, and applies it to target, following the rules of ReadJSONPatch. A null member in the
+// patch removes that member from the document.
+func (t *Tools) ReadMergePatch(w http.ResponseWriter, r *http.Request, target any, allowed ...string) error {
+	var raw json.RawMessage
+	if err := t.ReadJSON(w, r, &raw); err != nil {
+		return err
+	}
+
+	patch, err := decodeDocument(raw)
+	if err != nil {
+		return err
+	}
+
+	if len(allowed) > 0 {
+		if path, ok := mergePatchAllowed(patch, "", allowed); !ok {
+			return &PatchError{Kind: PatchForbiddenPath, Index: -1, Path: path, Status: http.StatusForbidden}
+		}
+	}
+
+	return t.patch(target, func(doc any) (any, error) {
+		return mergePatch(doc, patch), nil
+	})
+}
+
+// patch converts target to a document, passes it to apply, and decodes the result into a new
+// value that replaces target. Fields of a struct that have no JSON form, such as unexported fields
+// and those tagged "-", are not in the document, so they are carried over from target.
+func (t *Tools) patch(target any, apply func(doc any) (any, error)) error {
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return errors.New("patch target must be a non-nil pointer")
+	}
+
+	current, err := json.Marshal(target)
+	if err != nil {
+		return err
+	}
+	doc, err := decodeDocument(current)
+	if err != nil {
+		return err
+	}
+
+	doc, err = apply(doc)
+	if err != nil {
+		return err
+	}
+
+	patched, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	result := reflect.New(v.Elem().Type())
+	if v.Elem().Kind() == reflect.Struct {
+		result.Elem().Set(v.Elem())
+		if err := clearJSONFields(result.Elem()); err != nil {
+			return err
+		}
+	}
+	dec := json.NewDecoder(bytes.NewReader(patched))
+	if !t.AllowUnknownFields {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(result.Interface()); err != nil {
+		return &PatchError{Kind: PatchInvalidResult, Index: -1, Status: http.StatusUnprocessableEntity, Err: err}
+	}
+
+	if t.ValidateJSON {
+		if err := t.Validate(result.Interface()); err != nil {
+			return err
+		}
+	}
+
+	v.Elem().Set(result.Elem())
+	return nil
+}
+
+// clearJSONFields zeroes the fields of the struct v that are encoded as JSON, leaving the others
+// as they are. The fields of embedded and nested structs are cleared in place, or in a copy for a
+// pointer, so that the value it points to is not changed. A pointer to an unexported struct cannot
+// be replaced by a copy, so it is rejected.
+func clearJSONFields(v reflect.Value) error {
+	typ := v.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		f, field := typ.Field(i), v.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		if !f.IsExported() && !(f.Anonymous && strings.Split(tag, ",")[0] == "") {
+			continue
+		}
+
+		switch {
+		case mergeableStruct(f.Type):
+			if err := clearJSONFields(field); err != nil {
+				return err
+			}
+		case f.Type.Kind() == reflect.Pointer && mergeableStruct(f.Type.Elem()):
+			if field.IsNil() {
+				continue
+			}
+			if !field.CanSet() {
+				return fmt.Errorf("patch target embeds a pointer to the unexported struct %s", f.Type.Elem())
+			}
+			nested := reflect.New(f.Type.Elem())
+			nested.Elem().Set(field.Elem())
+			if err := clearJSONFields(nested.Elem()); err != nil {
+				return err
+			}
+			field.Set(nested)
+		case field.CanSet():
+			field.Set(reflect.Zero(f.Type))
+		}
+	}
+
+	return nil
+}
+
+var (
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// mergeableStruct reports whether typ is a struct that encoding/json decodes field by field, so
+// that decoding into it leaves the fields missing from the JSON as they were
+func mergeableStruct(typ reflect.Type) bool {
+	if typ.Kind() != reflect.Struct {
+		return false
+	}
+
+	ptr := reflect.PointerTo(typ)
+	return !ptr.Implements(jsonUnmarshalerType) && !ptr.Implements(textUnmarshalerType)
+}
+
+// decodeDocument decodes data keeping numbers as json.Number, so that they survive unchanged
+func decodeDocument(data []byte) (any, error) {
+	var doc any
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// applyJSONPatch applies ops to doc in order, stopping at the first that fails
+func applyJSONPatch(doc any, ops []PatchOperation, allowed []string) (any, error) {
+	for i, op := range ops {
+		var err error
+		doc, err = applyOperation(doc, op, allowed)
+		if err != nil {
+			var patchErr *PatchError
+			if !errors.As(err, &patchErr) {
+				patchErr = &PatchError{Kind: PatchInvalidOperation, Status: http.StatusBadRequest, Err: err}
+			}
+			patchErr.Index, patchErr.Op = i, op.Op
+			return nil, patchErr
+		}
+	}
+	return doc, nil
+}
+
+func applyOperation(doc any, op PatchOperation, allowed []string) (any, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, &PatchError{Kind: PatchInvalidPath, Path: op.Path, Status: http.StatusBadRequest, Err: err}
+	}
+
+	var value any
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, fmt.Errorf("%s operation has no value", op.Op)
+		}
+		if value, err = decodeDocument(op.Value); err != nil {
+			return nil, err
+		}
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, &PatchError{Kind: PatchInvalidPath, Path: op.From, Status: http.StatusBadRequest, Err: err}
+		}
+		if value, err = getPointer(doc, from, op.From); err != nil {
+			return nil, err
+		}
+		if op.Op == "move" {
+			if len(from) < len(path) && reflect.DeepEqual(from, path[:len(from)]) {
+				return nil, fmt.Errorf("cannot move %q into one of its children", op.From)
+			}
+			if !pathAllowed(allowed, from) {
+				return nil, &PatchError{Kind: PatchForbiddenPath, Path: op.From, Status: http.StatusForbidden}
+			}
+			if doc, err = removePointer(doc, from, op.From); err != nil {
+				return nil, err
+			}
+		} else {
+			value = copyDocument(value)
+		}
+	case "remove":
+	default:
+		return nil, fmt.Errorf("unknown operation %q", op.Op)
+	}
+
+	if op.Op == "test" {
+		current, err := getPointer(doc, path, op.Path)
+		if err != nil {
+			return nil, err
+		}
+		if !documentsEqual(current, value) {
+			return nil, &PatchError{Kind: PatchTestFailed, Path: op.Path, Status: http.StatusConflict}
+		}
+		return doc, nil
+	}
+
+	if !pathAllowed(allowed, path) {
+		return nil, &PatchError{Kind: PatchForbiddenPath, Path: op.Path, Status: http.StatusForbidden}
+	}
+
+	switch op.Op {
+	case "remove":
+		return removePointer(doc, path, op.Path)
+	case "replace":
+		if _, err := getPointer(doc, path, op.Path); err != nil {
+			return nil, err
+		}
+		if doc, err = removePointer(doc, path, op.Path); err != nil {
+			return nil, err
+		}
+		return addPointer(doc, path, op.Path, value)
+	default:
+		return addPointer(doc, path, op.Path, value)
+	}
+}
+
+// parsePointer splits an RFC 6901 JSON Pointer into its unescaped reference tokens
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, errors.New("a JSON Pointer must start with /")
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		for j := 0; j < len(token); j++ {
+			if token[j] == '~' && (j+1 == len(token) || (token[j+1] != '0' && token[j+1] != '1')) {
+				return nil, errors.New("~ must be followed by 0 or 1")
+			}
+		}
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func notFound(pointer string) error {
+	return &PatchError{Kind: PatchPathNotFound, Path: pointer, Status: http.StatusUnprocessableEntity}
+}
+
+// arrayIndex parses token as an index into an array of length n. The index n itself is only
+// allowed when end is set, for adding to the end of the array.
+func arrayIndex(token string, n int, end bool) (int, bool) {
+	if token == "-" && end {
+		return n, true
+	}
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, false
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || i > n || (i == n && !end) {
+		return 0, false
+	}
+	return i, true
+}
+
+// getPointer returns the value at path in doc
+func getPointer(doc any, path []string, pointer string) (any, error) {
+	for _, token := range path {
+		switch container := doc.(type) {
+		case map[string]any:
+			value, ok := container[token]
+			if !ok {
+				return nil, notFound(pointer)
+			}
+			doc = value
+		case []any:
+			i, ok := arrayIndex(token, len(container), false)
+			if !ok {
+				return nil, notFound(pointer)
+			}
+			doc = container[i]
+		default:
+			return nil, notFound(pointer)
+		}
+	}
+	return doc, nil
+}
+
+// updatePointer calls fn with the container holding the last token of path, and the token, and
+// stores the container fn returns in its place. It returns the updated document.
+func updatePointer(doc any, path []string, pointer string, fn func(container any, token string) (any, error)) (any, error) {
+	if len(path) == 1 {
+		return fn(doc, path[0])
+	}
+
+	child, err := getPointer(doc, path[:1], pointer)
+	if err != nil {
+		return nil, err
+	}
+	child, err = updatePointer(child, path[1:], pointer, fn)
+	if err != nil {
+		return nil, err
+	}
+
+	switch container := doc.(type) {
+	case map[string]any:
+		container[path[0]] = child
+	case []any:
+		i, _ := arrayIndex(path[0], len(container), false)
+		container[i] = child
+	}
+	return doc, nil
+}
+
+// addPointer adds value at path, inserting it into an array or setting an object member
+func addPointer(doc any, path []string, pointer string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	return updatePointer(doc, path, pointer, func(container any, token string) (any, error) {
+		switch container := container.(type) {
+		case map[string]any:
+			container[token] = value
+			return container, nil
+		case []any:
+			i, ok := arrayIndex(token, len(container), true)
+			if !ok {
+				return nil, notFound(pointer)
+			}
+			container = append(container, nil)
+			copy(container[i+1:], container[i:])
+			container[i] = value
+			return container, nil
+		default:
+			return nil, notFound(pointer)
+		}
+	})
+}
+
+// removePointer removes the value at path, which must exist
+func removePointer(doc any, path []string, pointer string) (any, error) {
+	if len(path) == 0 {
+		return nil, nil
+	}
+
+	return updatePointer(doc, path, pointer, func(container any, token string) (any, error) {
+		switch container := container.(type) {
+		case map[string]any:
+			if _, ok := container[token]; !ok {
+				return nil, notFound(pointer)
+			}
+			delete(container, token)
+			return container, nil
+		case []any:
+			i, ok := arrayIndex(token, len(container), false)
+			if !ok {
+				return nil, notFound(pointer)
+			}
+			return append(container[:i], container[i+1:]...), nil
+		default:
+			return nil, notFound(pointer)
+		}
+	})
+}
+
+// copyDocument returns a deep copy of doc
+func copyDocument(doc any) any {
+	switch doc := doc.(type) {
+	case map[string]any:
+		c := make(map[string]any, len(doc))
+		for key, value := range doc {
+			c[key] = copyDocument(value)
+		}
+		return c
+	case []any:
+		c := make([]any, len(doc))
+		for i, value := range doc {
+			c[i] = copyDocument(value)
+		}
+		return c
+	default:
+		return doc
+	}
+}
+
+// documentsEqual compares two documents as JSON values, so that numbers such as 1 and 1.0 are
+// equal
+func documentsEqual(a, b any) bool {
+	switch a := a.(type) {
+	case map[string]any:
+		b, ok := b.(map[string]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for key, value := range a {
+			other, ok := b[key]
+			if !ok || !documentsEqual(value, other) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		b, ok := b.([]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !documentsEqual(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	case json.Number:
+		b, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		x, okX := new(big.Rat).SetString(a.String())
+		y, okY := new(big.Rat).SetString(b.String())
+		return okX && okY && x.Cmp(y) == 0
+	default:
+		return a == b
+	}
+}
+
+// mergePatch applies an RFC 7396 merge patch to doc
+func mergePatch(doc, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	d, ok := doc.(map[string]any)
+	if !ok {
+		d = map[string]any{}
+	}
+	for key, value := range p {
+		if value == nil {
+			delete(d, key)
+		} else {
+			d[key] = mergePatch(d[key], value)
+		}
+	}
+	return d
+}
+
+// mergePatchAllowed checks every path a merge patch changes against allowed, returning the first
+// that is not allowed
+func mergePatchAllowed(patch any, pointer string, allowed []string) (string, bool) {
+	members, ok := patch.(map[string]any)
+	if !ok || len(members) == 0 {
+		path, _ := parsePointer(pointer)
+		return pointer, pathAllowed(allowed, path)
+	}
+
+	for key, value := range members {
+		escaped := strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+		if path, ok := mergePatchAllowed(value, pointer+"/"+escaped, allowed); !ok {
+			return path, false
+		}
+	}
+	return "", true
+}
+
+// pathAllowed reports whether path is, or is below, one of the allowed JSON Pointers. Every path
+// is allowed when the list is empty.
+func pathAllowed(allowed []string, path []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+
+	for _, pointer := range allowed {
+		pattern, err := parsePointer(pointer)
+		if err == nil && isPrefix(pattern, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPrefix reports whether the tokens of prefix start path, with "*" in prefix matching any token
+func isPrefix(prefix, path []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for i, token := range prefix {
+		if token != path[i] && token != "*" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,317 @@
+package gotoolkit
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testPatchTarget struct {
+	Title  string            `json:"title"`
+	Price  int64             `json:"price"`
+	Tags   []string          `json:"tags"`
+	Owner  string            `json:"owner"`
+	Extras map[string]string `json:"extras,omitempty"`
+}
+
+func testPatchTargetValue() testPatchTarget {
+	return testPatchTarget{Title: "Cabin", Price: 100, Tags: []string{"lake", "quiet"}, Owner: "ann"}
+}
+
+var jsonPatchTests = []struct {
+	name     string
+	patch    string
+	allowed  []string
+	expected testPatchTarget
+	kind     PatchErrorKind
+	status   int
+}{
+	{
+		name:     "replace",
+		patch:    `[{"op": "replace", "path": "/title", "value": "Lodge"}]`,
+		expected: testPatchTarget{Title: "Lodge", Price: 100, Tags: []string{"lake", "quiet"}, Owner: "ann"},
+	},
+	{
+		name:     "add and remove in arrays",
+		patch:    `[{"op": "add", "path": "/tags/-", "value": "pets"}, {"op": "add", "path": "/tags/0", "value": "new"}, {"op": "remove", "path": "/tags/2"}]`,
+		expected: testPatchTarget{Title: "Cabin", Price: 100, Tags: []string{"new", "lake", "pets"}, Owner: "ann"},
+	},
+	{
+		name:     "add member",
+		patch:    `[{"op": "add", "path": "/extras", "value": {}}, {"op": "add", "path": "/extras/a~1b", "value": "c"}]`,
+		expected: testPatchTarget{Title: "Cabin", Price: 100, Tags: []string{"lake", "quiet"}, Owner: "ann", Extras: map[string]string{"a/b": "c"}},
+	},
+	{
+		name:     "move and copy",
+		patch:    `[{"op": "copy", "from": "/tags/0", "path": "/tags/-"}, {"op": "move", "from": "/tags/0", "path": "/title"}]`,
+		expected: testPatchTarget{Title: "lake", Price: 100, Tags: []string{"quiet", "lake"}, Owner: "ann"},
+	},
+	{
+		name:     "passing test",
+		patch:    `[{"op": "test", "path": "/price", "value": 100.0}, {"op": "test", "path": "/tags", "value": ["lake", "quiet"]}, {"op": "replace", "path": "/price", "value": 90}]`,
+		expected: testPatchTarget{Title: "Cabin", Price: 90, Tags: []string{"lake", "quiet"}, Owner: "ann"},
+	},
+	{
+		name:     "unknown members are ignored",
+		patch:    `[{"op": "replace", "path": "/price", "value": 90, "comment": "sale"}]`,
+		expected: testPatchTarget{Title: "Cabin", Price: 90, Tags: []string{"lake", "quiet"}, Owner: "ann"},
+	},
+	{
+		name:     "allowed path",
+		patch:    `[{"op": "replace", "path": "/tags/1", "value": "calm"}]`,
+		allowed:  []string{"/title", "/tags"},
+		expected: testPatchTarget{Title: "Cabin", Price: 100, Tags: []string{"lake", "calm"}, Owner: "ann"},
+	},
+	{name: "failing test", patch: `[{"op": "replace", "path": "/price", "value": 1}, {"op": "test", "path": "/title", "value": "Lodge"}]`, kind: PatchTestFailed, status: http.StatusConflict},
+	{name: "missing path", patch: `[{"op": "replace", "path": "/rooms", "value": 1}]`, kind: PatchPathNotFound, status: http.StatusUnprocessableEntity},
+	{name: "index out of range", patch: `[{"op": "remove", "path": "/tags/5"}]`, kind: PatchPathNotFound, status: http.StatusUnprocessableEntity},
+	{name: "leading zero index", patch: `[{"op": "remove", "path": "/tags/01"}]`, kind: PatchPathNotFound, status: http.StatusUnprocessableEntity},
+	{name: "invalid pointer", patch: `[{"op": "remove", "path": "title"}]`, kind: PatchInvalidPath, status: http.StatusBadRequest},
+	{name: "invalid escape", patch: `[{"op": "remove", "path": "/a~2"}]`, kind: PatchInvalidPath, status: http.StatusBadRequest},
+	{name: "unknown op", patch: `[{"op": "increment", "path": "/price"}]`, kind: PatchInvalidOperation, status: http.StatusBadRequest},
+	{name: "missing value", patch: `[{"op": "add", "path": "/title"}]`, kind: PatchInvalidOperation, status: http.StatusBadRequest},
+	{name: "move into child", patch: `[{"op": "move", "from": "/tags", "path": "/tags/0"}]`, kind: PatchInvalidOperation, status: http.StatusBadRequest},
+	{name: "protected field", patch: `[{"op": "replace", "path": "/owner", "value": "eve"}]`, allowed: []string{"/title", "/tags"}, kind: PatchForbiddenPath, status: http.StatusForbidden},
+	{name: "protected move source", patch: `[{"op": "move", "from": "/owner", "path": "/title"}]`, allowed: []string{"/title"}, kind: PatchForbiddenPath, status: http.StatusForbidden},
+	{name: "unknown field in result", patch: `[{"op": "add", "path": "/rooms", "value": 3}]`, kind: PatchInvalidResult, status: http.StatusUnprocessableEntity},
+	{name: "wrong type in result", patch: `[{"op": "replace", "path": "/price", "value": "free"}]`, kind: PatchInvalidResult, status: http.StatusUnprocessableEntity},
+}
+
+func TestTools_ReadJSONPatch(t *testing.T) {
+	var testTools Tools
+
+	for _, e := range jsonPatchTests {
+		req := httptest.NewRequest("PATCH", "/", strings.NewReader(e.patch))
+		req.Header.Set("Content-Type", "application/json-patch+json")
+
+		listing := testPatchTargetValue()
+		err := testTools.ReadJSONPatch(httptest.NewRecorder(), req, &listing, e.allowed...)
+
+		if e.kind == 0 {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", e.name, err)
+			} else if !reflect.DeepEqual(listing, e.expected) {
+				t.Errorf("%s: expected %+v, got %+v", e.name, e.expected, listing)
+			}
+			continue
+		}
+
+		var patchErr *PatchError
+		if !errors.As(err, &patchErr) || patchErr.Kind != e.kind {
+			t.Errorf("%s: expected a %s error, got %v", e.name, e.kind, err)
+			continue
+		}
+		if !reflect.DeepEqual(listing, testPatchTargetValue()) {
+			t.Errorf("%s: target was changed by a failed patch: %+v", e.name, listing)
+		}
+
+		rr := httptest.NewRecorder()
+		_ = testTools.ErrorJSON(rr, err)
+		if rr.Code != e.status {
+			t.Errorf("%s: expected status %d, got %d", e.name, e.status, rr.Code)
+		}
+	}
+}
+
+func TestTools_ReadJSONPatchErrors(t *testing.T) {
+	testTools := Tools{MaxJSONSize: 64}
+
+	req := httptest.NewRequest("PATCH", "/", strings.NewReader(`{"op": "remove", "path": "/title"}`))
+	listing := testPatchTargetValue()
+	var jsonErr *JSONError
+	if err := testTools.ReadJSONPatch(httptest.NewRecorder(), req, &listing); !errors.As(err, &jsonErr) || jsonErr.Kind != JSONTypeMismatch {
+		t.Errorf("expected a type mismatch for a patch that is not an array, got %v", err)
+	}
+
+	req = httptest.NewRequest("PATCH", "/", strings.NewReader(`[{"op": "replace", "path": "/title", "value": "`+strings.Repeat("a", 64)+`"}]`))
+	if err := testTools.ReadJSONPatch(httptest.NewRecorder(), req, &listing); !errors.As(err, &jsonErr) || jsonErr.Kind != JSONTooLarge {
+		t.Errorf("expected a too large error, got %v", err)
+	}
+
+	req = httptest.NewRequest("PATCH", "/", strings.NewReader(`[{"op": "remove", "path": "/tags/9"}]`))
+	err := testTools.ReadJSONPatch(httptest.NewRecorder(), req, &listing)
+	if err == nil || err.Error() != `patch operation 0: path "/tags/9" does not exist` {
+		t.Errorf("unexpected error message %v", err)
+	}
+}
+
+func TestTools_ReadJSONPatchDocument(t *testing.T) {
+	var testTools Tools
+
+	doc := json.RawMessage(`{"id": 12345678901234567890, "name": "a"}`)
+	req := httptest.NewRequest("PATCH", "/", strings.NewReader(`[{"op": "add", "path": "/tags", "value": ["x"]}, {"op": "remove", "path": "/name"}]`))
+	if err := testTools.ReadJSONPatch(httptest.NewRecorder(), req, &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	if string(doc) != `{"id":12345678901234567890,"tags":["x"]}` {
+		t.Errorf("unexpected document %s", doc)
+	}
+}
+
+func TestTools_ReadJSONPatchValidate(t *testing.T) {
+	testTools := Tools{ValidateJSON: true}
+
+	type item struct {
+		Name string `json:"name" validate:"required"`
+	}
+	value := item{Name: "a"}
+
+	req := httptest.NewRequest("PATCH", "/", strings.NewReader(`[{"op": "replace", "path": "/name", "value": ""}]`))
+	var validationErr *ValidationError
+	if err := testTools.ReadJSONPatch(httptest.NewRecorder(), req, &value); !errors.As(err, &validationErr) {
+		t.Errorf("expected a validation error, got %v", err)
+	}
+	if value.Name != "a" {
+		t.Error("target was changed by a patch that failed validation")
+	}
+}
+
+var mergePatchTests = []struct {
+	name     string
+	patch    string
+	allowed  []string
+	expected testPatchTarget
+	kind     PatchErrorKind
+}{
+	{
+		name:     "merge",
+		patch:    `{"title": "Lodge", "tags": ["snow"]}`,
+		expected: testPatchTarget{Title: "Lodge", Price: 100, Tags: []string{"snow"}, Owner: "ann"},
+	},
+	{
+		name:     "null removes",
+		patch:    `{"tags": null, "extras": {"a": "b"}}`,
+		expected: testPatchTarget{Title: "Cabin", Price: 100, Owner: "ann", Extras: map[string]string{"a": "b"}},
+	},
+	{
+		name:     "allowed nested path",
+		patch:    `{"extras": {"a": "b"}}`,
+		allowed:  []string{"/extras"},
+		expected: testPatchTarget{Title: "Cabin", Price: 100, Tags: []string{"lake", "quiet"}, Owner: "ann", Extras: map[string]string{"a": "b"}},
+	},
+	{name: "protected field", patch: `{"title": "Lodge", "owner": "eve"}`, allowed: []string{"/title"}, kind: PatchForbiddenPath},
+	{name: "protected removal", patch: `{"owner": null}`, allowed: []string{"/title"}, kind: PatchForbiddenPath},
+	{name: "unknown field", patch: `{"rooms": 3}`, kind: PatchInvalidResult},
+}
+
+func TestTools_ReadMergePatch(t *testing.T) {
+	var testTools Tools
+
+	for _, e := range mergePatchTests {
+		req := httptest.NewRequest("PATCH", "/", strings.NewReader(e.patch))
+		req.Header.Set("Content-Type", "application/merge-patch+json")
+
+		listing := testPatchTargetValue()
+		err := testTools.ReadMergePatch(httptest.NewRecorder(), req, &listing, e.allowed...)
+
+		if e.kind == 0 {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", e.name, err)
+			} else if !reflect.DeepEqual(listing, e.expected) {
+				t.Errorf("%s: expected %+v, got %+v", e.name, e.expected, listing)
+			}
+			continue
+		}
+
+		var patchErr *PatchError
+		if !errors.As(err, &patchErr) || patchErr.Kind != e.kind || patchErr.Index != -1 {
+			t.Errorf("%s: expected a %s error, got %v", e.name, e.kind, err)
+		}
+		if !reflect.DeepEqual(listing, testPatchTargetValue()) {
+			t.Errorf("%s: target was changed by a failed patch: %+v", e.name, listing)
+		}
+	}
+}
+
+type testPatchBase struct {
+	Created  string `json:"created"`
+	Revision int    `json:"-"`
+}
+
+type testProtectedTarget struct {
+	testPatchBase
+	Title   string `json:"title"`
+	OwnerID int    `json:"-"`
+	secret  string
+}
+
+func TestTools_PatchKeepsFieldsWithoutJSON(t *testing.T) {
+	var testTools Tools
+
+	listing := testProtectedTarget{testPatchBase: testPatchBase{Created: "2023-01-02", Revision: 7}, Title: "old", OwnerID: 42, secret: "s"}
+
+	req := httptest.NewRequest("PATCH", "/", strings.NewReader(`{"title": "new", "created": "2024-05-06"}`))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	if err := testTools.ReadMergePatch(httptest.NewRecorder(), req, &listing, "/title", "/created"); err != nil {
+		t.Fatal(err)
+	}
+
+	if listing.Title != "new" || listing.OwnerID != 42 || listing.secret != "s" {
+		t.Errorf("expected only the title to change, got %+v", listing)
+	}
+	if listing.Created != "2024-05-06" || listing.Revision != 7 {
+		t.Errorf("expected the embedded struct to be patched, got %+v", listing.testPatchBase)
+	}
+
+	req = httptest.NewRequest("PATCH", "/", strings.NewReader(`[{"op": "replace", "path": "/title", "value": "newer"}]`))
+	req.Header.Set("Content-Type", "application/json-patch+json")
+	if err := testTools.ReadJSONPatch(httptest.NewRecorder(), req, &listing, "/title"); err != nil {
+		t.Fatal(err)
+	}
+	if listing.Title != "newer" || listing.OwnerID != 42 || listing.secret != "s" {
+		t.Errorf("expected only the title to change, got %+v", listing)
+	}
+}
+
+type testPatchAddress struct {
+	Street     string `json:"street"`
+	Verified   bool   `json:"-"`
+	PostalCode string `json:"postalCode,omitempty"`
+}
+
+type testNestedTarget struct {
+	Address testPatchAddress  `json:"address"`
+	Billing *testPatchAddress `json:"billing"`
+	Updated time.Time         `json:"updated"`
+}
+
+func TestTools_PatchKeepsNestedFieldsWithoutJSON(t *testing.T) {
+	var testTools Tools
+
+	billing := &testPatchAddress{Street: "1 Bank St", Verified: true, PostalCode: "B1"}
+	listing := testNestedTarget{
+		Address: testPatchAddress{Street: "1 High St", Verified: true, PostalCode: "A1"},
+		Billing: billing,
+		Updated: time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC),
+	}
+
+	req := httptest.NewRequest("PATCH", "/", strings.NewReader(`[
+		{"op": "replace", "path": "/address/street", "value": "2 High St"},
+		{"op": "remove", "path": "/address/postalCode"},
+		{"op": "replace", "path": "/billing/street", "value": "2 Bank St"},
+		{"op": "replace", "path": "/updated", "value": "2024-05-06T00:00:00Z"}
+	]`))
+	req.Header.Set("Content-Type", "application/json-patch+json")
+	if err := testTools.ReadJSONPatch(httptest.NewRecorder(), req, &listing); err != nil {
+		t.Fatal(err)
+	}
+
+	if listing.Address != (testPatchAddress{Street: "2 High St", Verified: true}) {
+		t.Errorf("expected the nested struct to be patched and keep Verified, got %+v", listing.Address)
+	}
+	if listing.Billing == billing || *listing.Billing != (testPatchAddress{Street: "2 Bank St", Verified: true, PostalCode: "B1"}) {
+		t.Errorf("expected a patched copy of the billing address, got %+v", listing.Billing)
+	}
+	if billing.Street != "1 Bank St" {
+		t.Errorf("expected the original billing address to be unchanged, got %+v", billing)
+	}
+	if !listing.Updated.Equal(time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected the time to be replaced, got %s", listing.Updated)
+	}
+}
@@ -0,0 +1,143 @@
+package gotoolkit
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// Problem is an error response in the format described by RFC 7807. Extensions holds any extra
+// members, such as the failing fields of a validation error, which are sent alongside the
+// standard ones. A Problem is also an error, so handlers can return one and pass it to ErrorJSON.
+type Problem struct {
+	Type       string
+	Title      string
+	Status     int
+	Detail     string
+	Instance   string
+	Extensions map[string]any
+}
+
+func (p *Problem) Error() string {
+	if p.Detail == "" {
+		return p.Title
+	}
+	if p.Title == "" {
+		return p.Detail
+	}
+	return p.Title + ": " + p.Detail
+}
+
+// MarshalJSON writes the standard members of p, and its extensions, as a single JSON object.
+// Extensions with the same name as a standard member are left out.
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	members := make(map[string]any, len(p.Extensions)+5)
+	for k, v := range p.Extensions {
+		members[k] = v
+	}
+
+	members["type"] = p.Type
+	if p.Type == "" {
+		members["type"] = "about:blank"
+	}
+	if p.Title != "" {
+		members["title"] = p.Title
+	} else {
+		delete(members, "title")
+	}
+	if p.Status != 0 {
+		members["status"] = p.Status
+	} else {
+		delete(members, "status")
+	}
+	if p.Detail != "" {
+		members["detail"] = p.Detail
+	} else {
+		delete(members, "detail")
+	}
+	if p.Instance != "" {
+		members["instance"] = p.Instance
+	} else {
+		delete(members, "instance")
+	}
+
+	return json.Marshal(members)
+}
+
+// UnmarshalJSON reads a problem document, keeping any members it does not know in Extensions
+func (p *Problem) UnmarshalJSON(data []byte) error {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(data, &members); err != nil {
+		return err
+	}
+
+	*p = Problem{}
+	standard := map[string]any{
+		"type":     &p.Type,
+		"title":    &p.Title,
+		"status":   &p.Status,
+		"detail":   &p.Detail,
+		"instance": &p.Instance,
+	}
+
+	for k, raw := range members {
+		if target, ok := standard[k]; ok {
+			if err := json.Unmarshal(raw, target); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var v any
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return err
+		}
+		if p.Extensions == nil {
+			p.Extensions = make(map[string]any)
+		}
+		p.Extensions[k] = v
+	}
+
+	return nil
+}
+
+// ProblemJSON sends p to the client as application/problem+json. The status code is taken from
+// p.Status, which defaults to 500 Internal Server Error, and the title defaults to the text for
+// that status.
+func (t *Tools) ProblemJSON(w http.ResponseWriter, p *Problem, headers ...http.Header) error {
+	problem := *p
+	if problem.Status == 0 {
+		problem.Status = http.StatusInternalServerError
+	}
+	if problem.Title == "" {
+		problem.Title = http.StatusText(problem.Status)
+	}
+
+	out, err := json.Marshal(&problem)
+	if err != nil {
+		return err
+	}
+
+	return t.send(w, problem.Status, "application/problem+json", out, headers...)
+}
+
+// problemFromError builds the Problem that ErrorJSON sends for err when Tools.ProblemErrors is set.
+// The failing fields of a *ValidationError are added as the "errors" extension.
+func problemFromError(err error, status int) *Problem {
+	var p *Problem
+	if errors.As(err, &p) {
+		problem := *p
+		problem.Status = status
+		return &problem
+	}
+
+	problem := &Problem{Status: status, Detail: err.Error()}
+
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		problem.Detail = "validation failed"
+		problem.Extensions = map[string]any{"errors": validationErr.Fields()}
+	}
+
+	return problem
+}
@@ -0,0 +1,143 @@
+package gotoolkit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestProblem_JSON(t *testing.T) {
+	p := &Problem{
+		Type:       "https://example.com/problems/out-of-credit",
+		Title:      "You do not have enough credit",
+		Status:     http.StatusForbidden,
+		Detail:     "Your current balance is 30, but that costs 50.",
+		Instance:   "/account/12345/msgs/abc",
+		Extensions: map[string]any{"balance": 30.0, "title": "ignored"},
+	}
+
+	out, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(out), "ignored") || !strings.Contains(string(out), `"balance":30`) {
+		t.Errorf("unexpected JSON %s", out)
+	}
+
+	var back Problem
+	if err := json.Unmarshal(out, &back); err != nil {
+		t.Fatal(err)
+	}
+	p.Extensions = map[string]any{"balance": 30.0}
+	if !reflect.DeepEqual(&back, p) {
+		t.Errorf("expected %+v, got %+v", p, back)
+	}
+
+	out, _ = json.Marshal(&Problem{})
+	if string(out) != `{"type":"about:blank"}` {
+		t.Errorf("unexpected JSON for an empty problem %s", out)
+	}
+}
+
+func TestTools_ProblemJSON(t *testing.T) {
+	var testTools Tools
+
+	rr := httptest.NewRecorder()
+	err := testTools.ProblemJSON(rr, &Problem{Status: http.StatusNotFound, Detail: "no such listing"}, http.Header{"X-Request-Id": {"abc"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr.Code != http.StatusNotFound || rr.Header().Get("Content-Type") != "application/problem+json" || rr.Header().Get("X-Request-Id") != "abc" {
+		t.Errorf("unexpected response %d %v", rr.Code, rr.Header())
+	}
+	expected := `{"detail":"no such listing","status":404,"title":"Not Found","type":"about:blank"}`
+	if rr.Body.String() != expected {
+		t.Errorf("expected %s, got %s", expected, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	_ = testTools.ProblemJSON(rr, &Problem{})
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("expected the status to default to 500, got %d", rr.Code)
+	}
+}
+
+func TestTools_ErrorJSONProblem(t *testing.T) {
+	testTools := Tools{ProblemErrors: true}
+
+	var tests = []struct {
+		name     string
+		err      error
+		status   []int
+		code     int
+		expected map[string]any
+	}{
+		{
+			name:     "plain error",
+			err:      errors.New("some error"),
+			code:     http.StatusBadRequest,
+			expected: map[string]any{"type": "about:blank", "title": "Bad Request", "status": 400.0, "detail": "some error"},
+		},
+		{
+			name:     "explicit status",
+			err:      errors.New("down for maintenance"),
+			status:   []int{http.StatusServiceUnavailable},
+			code:     http.StatusServiceUnavailable,
+			expected: map[string]any{"type": "about:blank", "title": "Service Unavailable", "status": 503.0, "detail": "down for maintenance"},
+		},
+		{
+			name:     "json error",
+			err:      &JSONError{Kind: JSONEmpty, Status: http.StatusBadRequest},
+			code:     http.StatusBadRequest,
+			expected: map[string]any{"type": "about:blank", "title": "Bad Request", "status": 400.0, "detail": "body must not be empty"},
+		},
+		{
+			name: "validation error",
+			err:  &ValidationError{Errors: []FieldError{{Field: "lat", Rule: "lat", Message: "must be a valid latitude"}}},
+			code: http.StatusBadRequest,
+			expected: map[string]any{"type": "about:blank", "title": "Bad Request", "status": 400.0, "detail": "validation failed",
+				"errors": map[string]any{"lat": "must be a valid latitude"}},
+		},
+		{
+			name:     "wrapped problem",
+			err:      fmt.Errorf("loading listing: %w", &Problem{Type: "https://example.com/gone", Title: "Listing removed", Status: http.StatusGone}),
+			code:     http.StatusGone,
+			expected: map[string]any{"type": "https://example.com/gone", "title": "Listing removed", "status": 410.0},
+		},
+	}
+
+	for _, e := range tests {
+		rr := httptest.NewRecorder()
+		if err := testTools.ErrorJSON(rr, e.err, e.status...); err != nil {
+			t.Fatal(err)
+		}
+
+		var got map[string]any
+		if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if rr.Code != e.code || rr.Header().Get("Content-Type") != "application/problem+json" {
+			t.Errorf("%s: unexpected response %d %s", e.name, rr.Code, rr.Header().Get("Content-Type"))
+		}
+		if !reflect.DeepEqual(got, e.expected) {
+			t.Errorf("%s: expected %v, got %v", e.name, e.expected, got)
+		}
+	}
+
+	testTools.ProblemErrors = false
+	rr := httptest.NewRecorder()
+	_ = testTools.ErrorJSON(rr, &Problem{Title: "Listing removed", Status: http.StatusGone})
+	var payload JSONResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &payload); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Code != http.StatusGone || payload.Message != "Listing removed" || rr.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("expected a legacy response, got %d %s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,133 @@
+package gotoolkit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// Scanner checks the content of an uploaded file, such as for malware, before it is saved.
+// Scan reads r to the end, and returns the name of the signature the content matched, or an
+// empty string if the content is clean.
+type Scanner interface {
+	Scan(ctx context.Context, r io.Reader) (string, error)
+}
+
+// InfectedFileError is returned when a Scanner finds a signature in an uploaded file
+type InfectedFileError struct {
+	FileName  string
+	Signature string
+}
+
+func (e *InfectedFileError) Error() string {
+	return fmt.Sprintf("the uploaded file %s is infected with %s", e.FileName, e.Signature)
+}
+
+// ClamdScanner is a Scanner that sends files to a clamd daemon using its INSTREAM command.
+// Network is "tcp" (the default) or "unix", and Address is host:port or the path of the socket.
+// StreamMaxLength must not be larger than the daemon's own StreamMaxLength setting.
+type ClamdScanner struct {
+	Network         string
+	Address         string
+	Timeout         time.Duration
+	ChunkSize       int
+	StreamMaxLength int64
+}
+
+// Scan streams r to clamd in chunks, and reports the signature it found, if any
+func (c *ClamdScanner) Scan(ctx context.Context, r io.Reader) (string, error) {
+	network := c.Network
+	if network == "" {
+		network = "tcp"
+	}
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	chunkSize := c.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = 64 * 1024
+	}
+
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, network, c.Address)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	_ = conn.SetDeadline(deadline)
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return "", err
+	}
+
+	var sent int64
+	buff := make([]byte, chunkSize)
+	size := make([]byte, 4)
+	for {
+		n, readErr := r.Read(buff)
+		if n > 0 {
+			sent += int64(n)
+			if c.StreamMaxLength > 0 && sent > c.StreamMaxLength {
+				return "", errors.New("clamd: file is larger than the stream limit")
+			}
+
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, err := conn.Write(size); err != nil {
+				return "", err
+			}
+			if _, err := conn.Write(buff[:n]); err != nil {
+				return "", err
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return "", readErr
+		}
+	}
+
+	// a zero length chunk marks the end of the stream
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return "", err
+	}
+
+	reply, err := bufio.NewReader(conn).ReadBytes(0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+
+	return parseClamdReply(string(bytes.TrimRight(reply, "\x00\n")))
+}
+
+// parseClamdReply interprets a reply such as "stream: OK" or "stream: Eicar-Signature FOUND"
+func parseClamdReply(reply string) (string, error) {
+	result := reply
+	if i := strings.Index(reply, ": "); i >= 0 {
+		result = reply[i+2:]
+	}
+
+	switch {
+	case result == "OK":
+		return "", nil
+	case strings.HasSuffix(result, " FOUND"):
+		return strings.TrimSuffix(result, " FOUND"), nil
+	case strings.HasSuffix(result, " ERROR"):
+		return "", fmt.Errorf("clamd: %s", strings.TrimSuffix(result, " ERROR"))
+	default:
+		return "", fmt.Errorf("clamd: unexpected reply %q", reply)
+	}
+}
@@ -0,0 +1,135 @@
+package gotoolkit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// fakeClamd accepts INSTREAM commands on l, and reports any stream containing the EICAR test
+// string as infected
+func fakeClamd(t *testing.T, l net.Listener) {
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func(conn net.Conn) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+
+				command, err := r.ReadString(0)
+				if err != nil || command != "zINSTREAM\x00" {
+					_, _ = conn.Write([]byte("UNKNOWN COMMAND\x00"))
+					return
+				}
+
+				var stream bytes.Buffer
+				size := make([]byte, 4)
+				for {
+					if _, err := io.ReadFull(r, size); err != nil {
+						return
+					}
+					n := binary.BigEndian.Uint32(size)
+					if n == 0 {
+						break
+					}
+					if _, err := io.CopyN(&stream, r, int64(n)); err != nil {
+						return
+					}
+				}
+
+				if strings.Contains(stream.String(), eicar) {
+					_, _ = conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+				} else {
+					_, _ = conn.Write([]byte("stream: OK\x00"))
+				}
+			}(conn)
+		}
+	}()
+}
+
+func TestClamdScanner_Scan(t *testing.T) {
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fakeClamd(t, tcp)
+
+	unix, err := net.Listen("unix", filepath.Join(t.TempDir(), "clamd.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fakeClamd(t, unix)
+
+	scanners := map[string]*ClamdScanner{
+		"tcp":  {Address: tcp.Addr().String(), ChunkSize: 16},
+		"unix": {Network: "unix", Address: unix.Addr().String()},
+	}
+
+	for name, scanner := range scanners {
+		signature, err := scanner.Scan(context.Background(), strings.NewReader("just a brochure"))
+		if err != nil || signature != "" {
+			t.Errorf("%s: expected clean result, got %q %v", name, signature, err)
+		}
+
+		signature, err = scanner.Scan(context.Background(), strings.NewReader("prefix "+eicar))
+		if err != nil || signature != "Eicar-Test-Signature" {
+			t.Errorf("%s: expected Eicar-Test-Signature, got %q %v", name, signature, err)
+		}
+	}
+}
+
+func TestParseClamdReply(t *testing.T) {
+	if _, err := parseClamdReply("INSTREAM size limit exceeded. ERROR"); err == nil {
+		t.Error("expected an error for an ERROR reply")
+	}
+	if _, err := parseClamdReply("something else"); err == nil {
+		t.Error("expected an error for an unexpected reply")
+	}
+}
+
+func TestTools_UploadFilesScanner(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fakeClamd(t, l)
+
+	store := &MemoryStorage{}
+	testTools := Tools{Storage: store, StreamUploads: true, Scanner: &ClamdScanner{Address: l.Addr().String()}}
+
+	_, err = testTools.UploadFiles(multipartRequest(t,
+		testPart{field: "file", fileName: "clean.txt", data: []byte("clean")},
+		testPart{field: "file", fileName: "virus.txt", data: []byte(eicar)},
+	), "")
+
+	var infected *InfectedFileError
+	if !errors.As(err, &infected) {
+		t.Fatalf("expected *InfectedFileError, got %v", err)
+	}
+	if infected.FileName != "virus.txt" || infected.Signature != "Eicar-Test-Signature" {
+		t.Errorf("unexpected infected file error: %+v", infected)
+	}
+
+	for _, key := range store.Keys() {
+		rc, _, _ := store.Get(context.Background(), key)
+		data, _ := io.ReadAll(rc)
+		if strings.Contains(string(data), "EICAR") {
+			t.Errorf("infected file was saved as %s", key)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package gotoolkit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrLinkInvalid is returned when a signed link is missing its signature, or has been changed
+	ErrLinkInvalid = errors.New("the link is not valid")
+	// ErrLinkExpired is returned when a correctly signed link has passed its expiry time
+	ErrLinkExpired = errors.New("the link has expired")
+)
+
+// SignURL returns link with an expiry time and an HMAC-SHA256 signature, made with
+// t.SigningKey, added to its query. The signature covers the path of the link and its expiry time.
+// If clientIP is given, usually from GetIP, the link only works for requests from that address.
+func (t *Tools) SignURL(link string, ttl time.Duration, clientIP ...string) (string, error) {
+	if len(t.SigningKey) == 0 {
+		return "", errors.New("no signing key has been set")
+	}
+
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", err
+	}
+
+	ip := ""
+	if len(clientIP) > 0 {
+		ip = clientIP[0]
+	}
+
+	expires := strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+
+	q := u.Query()
+	q.Del("bind")
+	if ip != "" {
+		q.Set("bind", "ip")
+	}
+	q.Set("expires", expires)
+	q.Set("signature", t.linkSignature(u.Path, expires, ip))
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+// VerifySignedURL checks the signature and expiry time of a link made by SignURL. It returns
+// ErrLinkInvalid if the link has been changed, or is used from the wrong address, and
+// ErrLinkExpired if it is too late to use it.
+func (t *Tools) VerifySignedURL(r *http.Request) error {
+	if len(t.SigningKey) == 0 {
+		return errors.New("no signing key has been set")
+	}
+
+	q := r.URL.Query()
+	expires := q.Get("expires")
+	signature := q.Get("signature")
+	if expires == "" || signature == "" {
+		return ErrLinkInvalid
+	}
+
+	ip := ""
+	if q.Get("bind") == "ip" {
+		var err error
+		if ip, err = t.GetIP(r); err != nil {
+			return ErrLinkInvalid
+		}
+	}
+
+	expected := t.linkSignature(r.URL.Path, expires, ip)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return ErrLinkInvalid
+	}
+
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return ErrLinkInvalid
+	}
+	if time.Now().Unix() > unix {
+		return ErrLinkExpired
+	}
+
+	return nil
+}
+
+// linkSignature returns the signature of a link to urlPath that expires at expires, for ip
+func (t *Tools) linkSignature(urlPath, expires, ip string) string {
+	mac := hmac.New(sha256.New, t.SigningKey)
+	mac.Write([]byte(urlPath + "\n" + expires + "\n" + ip))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// RequireSignedURL is middleware that only passes on requests for links made by SignURL. Other
+// requests get a 403 Forbidden response, or 410 Gone if the link has expired. It must see the
+// path the link was signed with, so it has to run before any http.StripPrefix.
+func (t *Tools) RequireSignedURL(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := t.VerifySignedURL(r)
+		switch {
+		case err == nil:
+			next.ServeHTTP(w, r)
+		case errors.Is(err, ErrLinkExpired):
+			http.Error(w, err.Error(), http.StatusGone)
+		default:
+			http.Error(w, ErrLinkInvalid.Error(), http.StatusForbidden)
+		}
+	})
+}
+
+// SignedFileServer returns a handler that serves the files in dir, for requests below prefix that
+// use a link made by SignURL. Files are sent with DownloadStaticFile, so browsers save them
+// rather than display them.
+func (t *Tools) SignedFileServer(prefix, dir string) http.Handler {
+	return t.RequireSignedURL(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, prefix)
+		if len(name) == len(r.URL.Path) && prefix != "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		name = path.Clean("/" + name)
+		if name == "/" {
+			http.NotFound(w, r)
+			return
+		}
+
+		pathName := filepath.Join(dir, filepath.FromSlash(name))
+		if fi, err := os.Stat(pathName); err != nil || fi.IsDir() {
+			http.NotFound(w, r)
+			return
+		}
+
+		t.DownloadStaticFile(w, r, pathName, path.Base(name))
+	}))
+}
@@ -0,0 +1,120 @@
+package gotoolkit
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTools_SignURL(t *testing.T) {
+	testTools := Tools{SigningKey: []byte("0123456789abcdef0123456789abcdef")}
+
+	link, err := testTools.SignURL("https://example.com/reports/valuation.pdf?ref=email", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(link, "https://example.com/reports/valuation.pdf?") || !strings.Contains(link, "ref=email") {
+		t.Errorf("unexpected link %s", link)
+	}
+
+	if err := testTools.VerifySignedURL(httptest.NewRequest("GET", link, nil)); err != nil {
+		t.Errorf("expected a valid link, got %v", err)
+	}
+
+	tampered := strings.Replace(link, "valuation.pdf", "other.pdf", 1)
+	if err := testTools.VerifySignedURL(httptest.NewRequest("GET", tampered, nil)); !errors.Is(err, ErrLinkInvalid) {
+		t.Errorf("expected ErrLinkInvalid for a changed path, got %v", err)
+	}
+
+	u, _ := url.Parse(link)
+	q := u.Query()
+	q.Set("expires", "9999999999")
+	u.RawQuery = q.Encode()
+	if err := testTools.VerifySignedURL(httptest.NewRequest("GET", u.String(), nil)); !errors.Is(err, ErrLinkInvalid) {
+		t.Errorf("expected ErrLinkInvalid for a changed expiry, got %v", err)
+	}
+
+	otherKey := Tools{SigningKey: []byte("another key")}
+	if err := otherKey.VerifySignedURL(httptest.NewRequest("GET", link, nil)); !errors.Is(err, ErrLinkInvalid) {
+		t.Errorf("expected ErrLinkInvalid for another key, got %v", err)
+	}
+
+	expired, _ := testTools.SignURL("/reports/valuation.pdf", -time.Minute)
+	if err := testTools.VerifySignedURL(httptest.NewRequest("GET", expired, nil)); !errors.Is(err, ErrLinkExpired) {
+		t.Errorf("expected ErrLinkExpired, got %v", err)
+	}
+
+	var noKey Tools
+	if _, err := noKey.SignURL("/reports/valuation.pdf", time.Hour); err == nil {
+		t.Error("expected an error without a signing key")
+	}
+}
+
+func TestTools_SignURLClientIP(t *testing.T) {
+	testTools := Tools{SigningKey: []byte("0123456789abcdef0123456789abcdef")}
+
+	link, _ := testTools.SignURL("/reports/valuation.pdf", time.Hour, "203.0.113.7")
+
+	req := httptest.NewRequest("GET", link, nil)
+	req.Header.Set("X-Real-IP", "203.0.113.7")
+	if err := testTools.VerifySignedURL(req); err != nil {
+		t.Errorf("expected a valid link, got %v", err)
+	}
+
+	req = httptest.NewRequest("GET", link, nil)
+	req.Header.Set("X-Real-IP", "198.51.100.1")
+	if err := testTools.VerifySignedURL(req); !errors.Is(err, ErrLinkInvalid) {
+		t.Errorf("expected ErrLinkInvalid from another address, got %v", err)
+	}
+
+	req = httptest.NewRequest("GET", strings.Replace(link, "bind=ip&", "", 1), nil)
+	req.Header.Set("X-Real-IP", "198.51.100.1")
+	if err := testTools.VerifySignedURL(req); !errors.Is(err, ErrLinkInvalid) {
+		t.Errorf("expected ErrLinkInvalid without the address binding, got %v", err)
+	}
+}
+
+func TestTools_SignedFileServer(t *testing.T) {
+	testTools := Tools{SigningKey: []byte("0123456789abcdef0123456789abcdef")}
+	handler := testTools.SignedFileServer("/files/", "./testdata")
+
+	sign := func(link string, ttl time.Duration) string {
+		signed, err := testTools.SignURL(link, ttl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	var tests = []struct {
+		name   string
+		link   string
+		status int
+	}{
+		{name: "valid", link: sign("/files/pic.jpg", time.Hour), status: http.StatusOK},
+		{name: "unsigned", link: "/files/pic.jpg", status: http.StatusForbidden},
+		{name: "expired", link: sign("/files/pic.jpg", -time.Hour), status: http.StatusGone},
+		{name: "missing file", link: sign("/files/nothing.jpg", time.Hour), status: http.StatusNotFound},
+		{name: "directory", link: sign("/files/uploads", time.Hour), status: http.StatusNotFound},
+		{name: "outside prefix", link: sign("/other/pic.jpg", time.Hour), status: http.StatusNotFound},
+		{name: "escape", link: sign("/files/../tools.go", time.Hour), status: http.StatusNotFound},
+	}
+
+	for _, e := range tests {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", e.link, nil))
+
+		if rr.Code != e.status {
+			t.Errorf("%s: expected status %d, got %d", e.name, e.status, rr.Code)
+		}
+		if e.status == http.StatusOK {
+			if rr.Body.Len() != 98827 || rr.Header().Get("Content-Disposition") != `attachment; filename="pic.jpg"` {
+				t.Errorf("%s: wrong download %d bytes, %s", e.name, rr.Body.Len(), rr.Header().Get("Content-Disposition"))
+			}
+		}
+	}
+}
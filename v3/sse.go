@@ -0,0 +1,198 @@
+package gotoolkit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrEventStreamClosed is returned by EventStream.Send after Close has been called
+var ErrEventStreamClosed = errors.New("event stream is closed")
+
+// Event is one Server-Sent Event. Data is sent as JSON, and an event without Data only updates
+// the ID or retry time on the client. Retry tells the client how long to wait before reconnecting.
+type Event struct {
+	ID    string
+	Event string
+	Retry time.Duration
+	Data  any
+}
+
+// EventStream sends Server-Sent Events to a client. It is safe for concurrent use, and must be
+// closed before the handler returns.
+type EventStream struct {
+	w           http.ResponseWriter
+	flusher     http.Flusher
+	ctx         context.Context
+	lastEventID string
+
+	mu      sync.Mutex
+	closed  bool
+	once    sync.Once
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// NewEventStream takes over w to send Server-Sent Events in response to r. It sends the headers
+// straight away, then a comment every t.EventHeartbeat, or 15 seconds, to keep the connection
+// open through proxies; a negative EventHeartbeat turns heartbeats off. Sending stops once the
+// client goes away and the context of r is cancelled.
+func (t *Tools) NewEventStream(w http.ResponseWriter, r *http.Request, headers ...http.Header) (*EventStream, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, errors.New("the response writer does not support streaming")
+	}
+
+	heartbeat := 15 * time.Second
+	if t.EventHeartbeat != 0 {
+		heartbeat = t.EventHeartbeat
+	}
+
+	if len(headers) > 0 {
+		for key, value := range headers[0] {
+			w.Header()[key] = value
+		}
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	s := &EventStream{
+		w:           w,
+		flusher:     flusher,
+		ctx:         r.Context(),
+		lastEventID: r.Header.Get("Last-Event-ID"),
+		stop:        make(chan struct{}),
+		stopped:     make(chan struct{}),
+	}
+
+	if heartbeat > 0 {
+		go s.heartbeat(heartbeat)
+	} else {
+		close(s.stopped)
+	}
+
+	return s, nil
+}
+
+// LastEventID returns the ID of the last event the client received before it reconnected, from
+// the Last-Event-ID header, so that the events it missed can be sent again
+func (s *EventStream) LastEventID() string {
+	return s.lastEventID
+}
+
+// Done is closed when the client has gone away
+func (s *EventStream) Done() <-chan struct{} {
+	return s.ctx.Done()
+}
+
+// Send sends e to the client. It returns the error of the request's context once the client has
+// gone away.
+func (s *EventStream) Send(e Event) error {
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	if strings.ContainsAny(e.ID, "\r\n\x00") || strings.ContainsAny(e.Event, "\r\n") {
+		return errors.New("event ids and names may not contain line breaks")
+	}
+
+	var b bytes.Buffer
+	if e.ID != "" {
+		b.WriteString("id: " + e.ID + "\n")
+	}
+	if e.Event != "" {
+		b.WriteString("event: " + e.Event + "\n")
+	}
+	if e.Retry > 0 {
+		fmt.Fprintf(&b, "retry: %d\n", e.Retry.Milliseconds())
+	}
+	if e.Data != nil {
+		out, err := json.Marshal(e.Data)
+		if err != nil {
+			return err
+		}
+		b.WriteString("data: ")
+		b.Write(out)
+		b.WriteString("\n")
+	}
+	b.WriteString("\n")
+
+	return s.write(b.Bytes())
+}
+
+// Run sends every event received from events until the channel is closed, which returns nil, or
+// the client goes away, which returns the error of the request's context
+func (s *EventStream) Run(events <-chan Event) error {
+	for {
+		select {
+		case <-s.ctx.Done():
+			return s.ctx.Err()
+		case e, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if err := s.Send(e); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Close stops the heartbeat. Nothing more can be sent once it returns.
+func (s *EventStream) Close() error {
+	s.once.Do(func() {
+		close(s.stop)
+		<-s.stopped
+
+		s.mu.Lock()
+		s.closed = true
+		s.mu.Unlock()
+	})
+	return nil
+}
+
+func (s *EventStream) write(p []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrEventStreamClosed
+	}
+	if _, err := s.w.Write(p); err != nil {
+		if ctxErr := s.ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return err
+	}
+	s.flusher.Flush()
+
+	return nil
+}
+
+func (s *EventStream) heartbeat(interval time.Duration) {
+	defer close(s.stopped)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if err := s.write([]byte(": heartbeat\n\n")); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package gotoolkit
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTools_EventStream(t *testing.T) {
+	testTools := Tools{EventHeartbeat: -1}
+
+	req := httptest.NewRequest("GET", "/events", nil)
+	req.Header.Set("Last-Event-ID", "41")
+	rr := httptest.NewRecorder()
+
+	s, err := testTools.NewEventStream(rr, req, http.Header{"X-Test": {"1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.LastEventID() != "41" {
+		t.Errorf("expected last event id 41, got %q", s.LastEventID())
+	}
+
+	var tests = []struct {
+		name     string
+		event    Event
+		expected string
+	}{
+		{name: "data only", event: Event{Data: map[string]int{"done": 40}}, expected: "data: {\"done\":40}\n\n"},
+		{name: "all fields", event: Event{ID: "42", Event: "progress", Retry: 3 * time.Second, Data: "a\nb"}, expected: "id: 42\nevent: progress\nretry: 3000\ndata: \"a\\nb\"\n\n"},
+		{name: "no data", event: Event{ID: "43"}, expected: "id: 43\n\n"},
+	}
+
+	for _, e := range tests {
+		rr.Body.Reset()
+		if err := s.Send(e.event); err != nil {
+			t.Fatalf("%s: %s", e.name, err)
+		}
+		if rr.Body.String() != e.expected {
+			t.Errorf("%s: expected %q, got %q", e.name, e.expected, rr.Body.String())
+		}
+	}
+
+	if err := s.Send(Event{ID: "1\ndata: injected"}); err == nil {
+		t.Error("expected an error for an id with a line break")
+	}
+	if err := s.Send(Event{Data: func() {}}); err == nil {
+		t.Error("expected an error for data that cannot be marshalled")
+	}
+
+	if rr.Header().Get("Content-Type") != "text/event-stream" || rr.Header().Get("Cache-Control") != "no-cache" || rr.Header().Get("X-Test") != "1" || !rr.Flushed {
+		t.Errorf("unexpected headers %v", rr.Header())
+	}
+
+	_ = s.Close()
+	if err := s.Send(Event{Data: 1}); !errors.Is(err, ErrEventStreamClosed) {
+		t.Errorf("expected ErrEventStreamClosed, got %v", err)
+	}
+}
+
+func TestTools_EventStreamHeartbeat(t *testing.T) {
+	testTools := Tools{EventHeartbeat: 5 * time.Millisecond}
+
+	rr := httptest.NewRecorder()
+	s, err := testTools.NewEventStream(rr, httptest.NewRequest("GET", "/events", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	_ = s.Close()
+
+	if !strings.Contains(rr.Body.String(), ": heartbeat\n\n") {
+		t.Errorf("expected heartbeats, got %q", rr.Body.String())
+	}
+}
+
+func TestTools_EventStreamRun(t *testing.T) {
+	testTools := Tools{EventHeartbeat: -1}
+
+	events := make(chan Event, 2)
+	events <- Event{ID: "1", Data: 1}
+	events <- Event{ID: "2", Data: 2}
+	close(events)
+
+	rr := httptest.NewRecorder()
+	s, _ := testTools.NewEventStream(rr, httptest.NewRequest("GET", "/events", nil))
+	defer s.Close()
+
+	if err := s.Run(events); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Body.String() != "id: 1\ndata: 1\n\nid: 2\ndata: 2\n\n" {
+		t.Errorf("unexpected stream %q", rr.Body.String())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s, _ = testTools.NewEventStream(httptest.NewRecorder(), httptest.NewRequest("GET", "/events", nil).WithContext(ctx))
+	defer s.Close()
+
+	if err := s.Run(make(chan Event)); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if err := s.Send(Event{Data: 1}); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestTools_EventStreamDisconnect(t *testing.T) {
+	var testTools Tools
+	result := make(chan error, 1)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, err := testTools.NewEventStream(w, r)
+		if err != nil {
+			result <- err
+			return
+		}
+		defer s.Close()
+
+		for i := 0; ; i++ {
+			if err := s.Send(Event{Event: "tick", Data: i}); err != nil {
+				result <- err
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil || line != "event: tick\n" {
+		t.Fatalf("expected the first event, got %q %v", line, err)
+	}
+	cancel()
+	resp.Body.Close()
+
+	select {
+	case err := <-result:
+		// the write usually fails after the context is cancelled, but may fail first
+		if err == nil {
+			t.Error("expected the stream to stop with an error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stream did not stop when the client went away")
+	}
+}
@@ -0,0 +1,309 @@
+package gotoolkit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrObjectNotFound is returned by a Storage when the requested key does not exist
+var ErrObjectNotFound = errors.New("storage object not found")
+
+// StorageObject describes an object held by a Storage backend
+type StorageObject struct {
+	Key         string
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+	ETag        string
+	URL         string
+}
+
+// Storage is implemented by anything that can hold uploaded files. Keys are slash separated
+// paths relative to the root of the storage. Put is given the size of the content when it is
+// known, and -1 when it is not.
+type Storage interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) (*StorageObject, error)
+	Get(ctx context.Context, key string) (io.ReadCloser, *StorageObject, error)
+	Delete(ctx context.Context, key string) error
+	Stat(ctx context.Context, key string) (*StorageObject, error)
+}
+
+// RangeStorage is implemented by a Storage that can read part of an object without fetching the
+// whole of it. Downloads use it to answer range requests.
+type RangeStorage interface {
+	GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+}
+
+// cleanKey normalises a storage key, and rejects keys that would escape the root of the storage
+func cleanKey(key string) (string, error) {
+	k := path.Clean("/" + strings.ReplaceAll(key, "\\", "/"))
+	k = strings.TrimPrefix(k, "/")
+	if k == "" || k == "." {
+		return "", errors.New("storage key must not be empty")
+	}
+	return k, nil
+}
+
+// LocalStorage stores objects as files below Root on the local file system. If BaseURL is set,
+// it is used to build the URL reported for each object.
+type LocalStorage struct {
+	Root    string
+	BaseURL string
+}
+
+func (s *LocalStorage) path(key string) (string, string, error) {
+	k, err := cleanKey(key)
+	if err != nil {
+		return "", "", err
+	}
+	return k, filepath.Join(s.Root, filepath.FromSlash(k)), nil
+}
+
+func (s *LocalStorage) object(key string, fi os.FileInfo) *StorageObject {
+	obj := &StorageObject{
+		Key:         key,
+		Size:        fi.Size(),
+		ContentType: mime.TypeByExtension(path.Ext(key)),
+		ModTime:     fi.ModTime(),
+		ETag:        fmt.Sprintf("\"%x-%x\"", fi.ModTime().UnixNano(), fi.Size()),
+	}
+	if s.BaseURL != "" {
+		obj.URL = strings.TrimSuffix(s.BaseURL, "/") + "/" + key
+	}
+	return obj
+}
+
+// Put writes r to the file named by key, creating any missing parent directories. The content is
+// written to a temporary file in the same directory, which is only renamed to its final name once
+// it is complete, so a failed or interrupted Put never leaves a partial file behind.
+func (s *LocalStorage) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) (*StorageObject, error) {
+	k, p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return nil, err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".upload-*.tmp")
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := func() (os.FileInfo, error) {
+		defer tmp.Close()
+
+		if _, err := io.Copy(tmp, r); err != nil {
+			return nil, err
+		}
+		if err := tmp.Sync(); err != nil {
+			return nil, err
+		}
+		if err := tmp.Chmod(0644); err != nil {
+			return nil, err
+		}
+		return tmp.Stat()
+	}()
+	if err == nil {
+		err = os.Rename(tmp.Name(), p)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return nil, err
+	}
+
+	obj := s.object(k, fi)
+	if contentType != "" {
+		obj.ContentType = contentType
+	}
+	return obj, nil
+}
+
+// Get opens the file named by key. The returned reader is an *os.File, so it can also be used
+// as an io.ReadSeeker.
+func (s *LocalStorage) Get(ctx context.Context, key string) (io.ReadCloser, *StorageObject, error) {
+	k, p, err := s.path(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, ErrObjectNotFound
+		}
+		return nil, nil, err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	return f, s.object(k, fi), nil
+}
+
+// Delete removes the file named by key
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+	_, p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(p)
+	if os.IsNotExist(err) {
+		return ErrObjectNotFound
+	}
+	return err
+}
+
+// Stat returns information about the file named by key
+func (s *LocalStorage) Stat(ctx context.Context, key string) (*StorageObject, error) {
+	k, p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := os.Stat(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, err
+	}
+	if fi.IsDir() {
+		return nil, ErrObjectNotFound
+	}
+
+	return s.object(k, fi), nil
+}
+
+type memoryObject struct {
+	data        []byte
+	contentType string
+	modTime     time.Time
+}
+
+// MemoryStorage keeps objects in memory. It is intended for tests, and its zero value is ready to use.
+type MemoryStorage struct {
+	mu      sync.RWMutex
+	objects map[string]*memoryObject
+}
+
+func (s *MemoryStorage) object(key string, o *memoryObject) *StorageObject {
+	return &StorageObject{
+		Key:         key,
+		Size:        int64(len(o.data)),
+		ContentType: o.contentType,
+		ModTime:     o.modTime,
+		ETag:        fmt.Sprintf("\"%x-%x\"", o.modTime.UnixNano(), len(o.data)),
+	}
+}
+
+// Put stores the content of r under key
+func (s *MemoryStorage) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) (*StorageObject, error) {
+	k, err := cleanKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	o := &memoryObject{data: data, contentType: contentType, modTime: time.Now()}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.objects == nil {
+		s.objects = make(map[string]*memoryObject)
+	}
+	s.objects[k] = o
+
+	return s.object(k, o), nil
+}
+
+// Get returns the content stored under key. The returned reader also implements io.ReadSeeker.
+func (s *MemoryStorage) Get(ctx context.Context, key string) (io.ReadCloser, *StorageObject, error) {
+	k, err := cleanKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	o, ok := s.objects[k]
+	if !ok {
+		return nil, nil, ErrObjectNotFound
+	}
+
+	return nopSeekCloser{bytes.NewReader(o.data)}, s.object(k, o), nil
+}
+
+// Delete removes the object stored under key
+func (s *MemoryStorage) Delete(ctx context.Context, key string) error {
+	k, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.objects[k]; !ok {
+		return ErrObjectNotFound
+	}
+	delete(s.objects, k)
+
+	return nil
+}
+
+// Stat returns information about the object stored under key
+func (s *MemoryStorage) Stat(ctx context.Context, key string) (*StorageObject, error) {
+	k, err := cleanKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	o, ok := s.objects[k]
+	if !ok {
+		return nil, ErrObjectNotFound
+	}
+
+	return s.object(k, o), nil
+}
+
+// Keys returns the sorted keys of every object held in the storage
+func (s *MemoryStorage) Keys() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]string, 0, len(s.objects))
+	for k := range s.objects {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+type nopSeekCloser struct {
+	*bytes.Reader
+}
+
+func (nopSeekCloser) Close() error { return nil }
@@ -0,0 +1,334 @@
+package gotoolkit
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+
+// S3Storage stores objects in a bucket of an S3 compatible object store, such as AWS S3 or MinIO.
+// Requests are signed with AWS signature version 4, and the bucket is addressed path style
+// (Endpoint/Bucket/Key), which is what MinIO and most other S3 compatible stores expect.
+type S3Storage struct {
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	Client          *http.Client
+}
+
+// s3Error is the XML error document returned by S3 compatible stores
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (s *S3Storage) client() *http.Client {
+	if s.Client != nil {
+		return s.Client
+	}
+	return http.DefaultClient
+}
+
+func (s *S3Storage) region() string {
+	if s.Region != "" {
+		return s.Region
+	}
+	return "us-east-1"
+}
+
+func (s *S3Storage) objectURL(key string) string {
+	return fmt.Sprintf("%s/%s/%s", strings.TrimSuffix(s.Endpoint, "/"), s3Escape(s.Bucket), s3EscapePath(key))
+}
+
+func (s *S3Storage) object(key string, h http.Header) *StorageObject {
+	obj := &StorageObject{
+		Key:         key,
+		ContentType: h.Get("Content-Type"),
+		ETag:        h.Get("ETag"),
+		URL:         s.objectURL(key),
+	}
+	if n, err := strconv.ParseInt(h.Get("Content-Length"), 10, 64); err == nil {
+		obj.Size = n
+	}
+	if t, err := http.ParseTime(h.Get("Last-Modified")); err == nil {
+		obj.ModTime = t
+	}
+	return obj
+}
+
+// do builds a request for key, and sends it with send
+func (s *S3Storage) do(ctx context.Context, method, key string, body io.Reader, size int64, contentType string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.objectURL(key), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	return s.send(req, key)
+}
+
+// send signs and sends req for key, and turns error responses into errors
+func (s *S3Storage) send(req *http.Request, key string) (*http.Response, error) {
+	s.sign(req, time.Now().UTC())
+
+	res, err := s.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode >= 300 {
+		defer res.Body.Close()
+		if res.StatusCode == http.StatusNotFound {
+			return nil, ErrObjectNotFound
+		}
+
+		var e s3Error
+		_ = xml.NewDecoder(io.LimitReader(res.Body, 64*1024)).Decode(&e)
+		if e.Code == "" {
+			e.Code = res.Status
+		}
+		return nil, fmt.Errorf("s3 %s %s: %s %s", req.Method, key, e.Code, e.Message)
+	}
+
+	return res, nil
+}
+
+// Put uploads r to key. S3 requires the length of the content up front, so when size is not
+// known the content is first spooled to a temporary file.
+func (s *S3Storage) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) (*StorageObject, error) {
+	k, err := cleanKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if size < 0 {
+		tmp, err := os.CreateTemp("", "s3-put-*")
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+
+		if size, err = io.Copy(tmp, r); err != nil {
+			return nil, err
+		}
+		if _, err = tmp.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		r = tmp
+	}
+
+	body := io.NopCloser(r)
+	if size == 0 {
+		body = http.NoBody
+	}
+
+	res, err := s.do(ctx, http.MethodPut, k, body, size, contentType)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+
+	return &StorageObject{
+		Key:         k,
+		Size:        size,
+		ContentType: contentType,
+		ModTime:     time.Now(),
+		ETag:        res.Header.Get("ETag"),
+		URL:         s.objectURL(k),
+	}, nil
+}
+
+// Get fetches the object stored under key. The caller must close the returned reader.
+func (s *S3Storage) Get(ctx context.Context, key string) (io.ReadCloser, *StorageObject, error) {
+	k, err := cleanKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	res, err := s.do(ctx, http.MethodGet, k, nil, 0, "")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return res.Body, s.object(k, res.Header), nil
+}
+
+// GetRange fetches length bytes of the object stored under key, starting at offset, with an
+// HTTP range request. The caller must close the returned reader.
+func (s *S3Storage) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	k, err := cleanKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.objectURL(k), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+
+	res, err := s.send(req, k)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusPartialContent {
+		res.Body.Close()
+		return nil, fmt.Errorf("s3 GET %s: range request returned %s", k, res.Status)
+	}
+
+	return res.Body, nil
+}
+
+// Delete removes the object stored under key
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	k, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.do(ctx, http.MethodDelete, k, nil, 0, "")
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	return nil
+}
+
+// Stat returns information about the object stored under key, without fetching its content
+func (s *S3Storage) Stat(ctx context.Context, key string) (*StorageObject, error) {
+	k, err := cleanKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.do(ctx, http.MethodHead, k, nil, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+
+	return s.object(k, res.Header), nil
+}
+
+// sign adds an AWS signature version 4 Authorization header to req. The payload is not
+// signed, so that content can be streamed without hashing it first.
+func (s *S3Storage) sign(req *http.Request, now time.Time) {
+	s.signPayload(req, now, s3UnsignedPayload)
+}
+
+// signPayload signs req as sign does, with payloadHash as the hex encoded SHA-256 sum of its body
+// or UNSIGNED-PAYLOAD
+func (s *S3Storage) signPayload(req *http.Request, now time.Time, payloadHash string) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "content-type" || lower == "range" || strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		s3CanonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{date, s.region(), "s3", "aws4_request"}, "/")
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(hashed[:])}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretAccessKey), date)
+	key = hmacSHA256(key, s.region())
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// s3CanonicalQuery returns query sorted and escaped as signature version 4 requires, with an
+// empty value written as "name="
+func s3CanonicalQuery(query url.Values) string {
+	params := make([]string, 0, len(query))
+	for name, values := range query {
+		for _, value := range values {
+			params = append(params, s3Escape(name)+"="+s3Escape(value))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Escape percent encodes everything except the unreserved characters of RFC 3986,
+// as required by signature version 4
+func s3Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func s3EscapePath(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = s3Escape(segment)
+	}
+	return strings.Join(segments, "/")
+}
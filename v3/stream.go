@@ -0,0 +1,308 @@
+package gotoolkit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// JSONStreamFormat is the format of a stream of JSON values
+type JSONStreamFormat int
+
+const (
+	// NDJSON writes one JSON value per line, as application/x-ndjson
+	NDJSON JSONStreamFormat = iota
+	// JSONArray writes the values as the elements of a single JSON array
+	JSONArray
+)
+
+// JSONStreamWriter writes values to a response one at a time, flushing each to the client as it
+// goes, so that a large result never has to be held in memory. Close must be called once the
+// last value has been written.
+type JSONStreamWriter struct {
+	w       http.ResponseWriter
+	status  int
+	format  JSONStreamFormat
+	headers http.Header
+	count   int
+	started bool
+}
+
+// NewJSONStreamWriter returns a JSONStreamWriter that sends its values to w in format. The status
+// and headers are only sent with the first value, or by Close if there are none, so an error
+// response can still be sent instead if the values cannot be fetched.
+func (t *Tools) NewJSONStreamWriter(w http.ResponseWriter, status int, format JSONStreamFormat, headers ...http.Header) *JSONStreamWriter {
+	s := &JSONStreamWriter{w: w, status: status, format: format}
+	if len(headers) > 0 {
+		s.headers = headers[0]
+	}
+	return s
+}
+
+func (s *JSONStreamWriter) start() error {
+	if s.started {
+		return nil
+	}
+	s.started = true
+
+	for key, value := range s.headers {
+		s.w.Header()[key] = value
+	}
+	if s.format == JSONArray {
+		s.w.Header().Set("Content-Type", "application/json")
+	} else {
+		s.w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	s.w.WriteHeader(s.status)
+
+	if s.format == JSONArray {
+		_, err := io.WriteString(s.w, "[")
+		return err
+	}
+	return nil
+}
+
+// Write sends v to the client
+func (s *JSONStreamWriter) Write(v any) error {
+	out, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	if err := s.start(); err != nil {
+		return err
+	}
+
+	switch {
+	case s.format == NDJSON:
+		out = append(out, '\n')
+	case s.count > 0:
+		out = append([]byte{','}, out...)
+	}
+	if _, err := s.w.Write(out); err != nil {
+		return err
+	}
+	s.count++
+
+	if f, ok := s.w.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	return nil
+}
+
+// Close ends the stream. It does not close the underlying connection.
+func (s *JSONStreamWriter) Close() error {
+	if err := s.start(); err != nil {
+		return err
+	}
+	if s.format == JSONArray {
+		_, err := io.WriteString(s.w, "]")
+		return err
+	}
+	return nil
+}
+
+// errElementTooLarge is returned by elementLimiter when a single value is larger than the limit
+var errElementTooLarge = errors.New("stream element too large")
+
+// JSONStreamReader reads a request body holding either a JSON array or NDJSON one value at a
+// time. Each value may not be larger than MaxJSONSize, and the whole body may not be larger than
+// MaxJSONStreamSize.
+type JSONStreamReader struct {
+	t          *Tools
+	dec        *json.Decoder
+	limiter    *elementLimiter
+	maxElement int64
+	index      int
+	started    bool
+	array      bool
+	err        error
+}
+
+// NewJSONStreamReader returns a JSONStreamReader for the body of r. Whether the body is a JSON
+// array or NDJSON is decided by its first character.
+func (t *Tools) NewJSONStreamReader(w http.ResponseWriter, r *http.Request) *JSONStreamReader {
+	maxElement := t.maxJSONSize()
+	maxTotal := 1024 * 1024 * 1024 // one gigabyte
+	if t.MaxJSONStreamSize != 0 {
+		maxTotal = t.MaxJSONStreamSize
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, int64(maxTotal))
+	limiter := &elementLimiter{r: bufio.NewReader(r.Body)}
+
+	dec := json.NewDecoder(limiter)
+	if !t.AllowUnknownFields {
+		dec.DisallowUnknownFields()
+	}
+
+	limiter.allow(int64(maxElement) + 1)
+
+	return &JSONStreamReader{t: t, dec: dec, limiter: limiter, maxElement: int64(maxElement)}
+}
+
+// Decode reads the next value into v. It returns io.EOF once every value has been read, and a
+// *JSONError if the body is not valid, or a value is too large or does not match v. Field in the
+// error is prefixed with the index of the value, such as "[3].price". When ValidateJSON is set,
+// each value is also validated.
+func (s *JSONStreamReader) Decode(v any) error {
+	if s.err != nil {
+		return s.err
+	}
+	if err := s.next(v); err != nil {
+		s.err = err
+		return err
+	}
+	return nil
+}
+
+func (s *JSONStreamReader) next(v any) error {
+	if !s.started {
+		s.started = true
+
+		b, err := s.limiter.peek()
+		if err == io.EOF {
+			return io.EOF
+		}
+		if err != nil {
+			return newJSONError(err, s.dec)
+		}
+		if b == '[' {
+			s.array = true
+			if _, err := s.dec.Token(); err != nil {
+				return newJSONError(err, s.dec)
+			}
+		}
+	}
+
+	s.limiter.allow(s.dec.InputOffset() + s.maxElement + 1)
+	if !s.dec.More() {
+		return s.end()
+	}
+
+	start := s.dec.InputOffset()
+	s.limiter.allow(start + s.maxElement + 1)
+
+	err := s.dec.Decode(v)
+	if err == nil && s.dec.InputOffset()-start > s.maxElement {
+		err = errElementTooLarge
+	}
+	if err != nil {
+		if errors.Is(err, errElementTooLarge) {
+			return &JSONError{
+				Kind:   JSONTooLarge,
+				Field:  fmt.Sprintf("[%d]", s.index),
+				Offset: start,
+				Status: http.StatusRequestEntityTooLarge,
+				Err:    fmt.Errorf("value %d is larger than %d bytes", s.index, s.maxElement),
+			}
+		}
+
+		err = newJSONError(err, s.dec)
+		var jsonErr *JSONError
+		if errors.As(err, &jsonErr) {
+			jsonErr.Field = fmt.Sprintf("[%d]", s.index) + fieldSuffix(jsonErr.Field)
+		}
+		return err
+	}
+
+	s.index++
+
+	if s.t.ValidateJSON {
+		if err := s.t.Validate(v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// end checks that nothing follows the last value
+func (s *JSONStreamReader) end() error {
+	s.limiter.allow(s.dec.InputOffset() + s.maxElement + 1)
+
+	if s.array {
+		if _, err := s.dec.Token(); err != nil {
+			return newJSONError(err, s.dec)
+		}
+	}
+
+	offset := s.dec.InputOffset()
+	if _, err := s.dec.Token(); err != io.EOF {
+		return &JSONError{Kind: JSONTrailingData, Offset: offset, Status: http.StatusBadRequest, Err: err}
+	}
+
+	return io.EOF
+}
+
+// fieldSuffix returns field ready to be appended to an array index
+func fieldSuffix(field string) string {
+	if field == "" {
+		return ""
+	}
+	return "." + field
+}
+
+// ReadJSONStream calls fn with each value in the body of r, which may be a JSON array or NDJSON,
+// using a JSONStreamReader. It stops at the first error, from the body or from fn.
+func ReadJSONStream[T any](t *Tools, w http.ResponseWriter, r *http.Request, fn func(item T) error) error {
+	s := t.NewJSONStreamReader(w, r)
+	for {
+		var item T
+		err := s.Decode(&item)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+}
+
+// elementLimiter stops a json.Decoder from reading more than one value's worth of data past the
+// start of the value, so an oversized value is rejected without being read into memory
+type elementLimiter struct {
+	r       *bufio.Reader
+	read    int64
+	allowed int64
+}
+
+func (l *elementLimiter) allow(n int64) {
+	l.allowed = n
+}
+
+// peek returns the first byte of the body that is not white space, without consuming anything.
+// If there is more white space than fits in the buffer, it returns a space.
+func (l *elementLimiter) peek() (byte, error) {
+	for i := 1; ; i++ {
+		buf, err := l.r.Peek(i)
+		if errors.Is(err, bufio.ErrBufferFull) {
+			return ' ', nil
+		}
+		if len(buf) < i {
+			return 0, err
+		}
+		if b := buf[i-1]; b != ' ' && b != '\t' && b != '\r' && b != '\n' {
+			return b, nil
+		}
+	}
+}
+
+func (l *elementLimiter) Read(p []byte) (int, error) {
+	if l.read >= l.allowed {
+		return 0, errElementTooLarge
+	}
+	if remaining := l.allowed - l.read; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	return n, err
+}
@@ -0,0 +1,159 @@
+package gotoolkit
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type testRow struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+func TestTools_JSONStreamWriter(t *testing.T) {
+	var testTools Tools
+
+	var tests = []struct {
+		name        string
+		format      JSONStreamFormat
+		rows        []testRow
+		expected    string
+		contentType string
+	}{
+		{name: "ndjson", format: NDJSON, rows: []testRow{{1, "a"}, {2, "b"}}, expected: "{\"id\":1,\"name\":\"a\"}\n{\"id\":2,\"name\":\"b\"}\n", contentType: "application/x-ndjson"},
+		{name: "array", format: JSONArray, rows: []testRow{{1, "a"}, {2, "b"}}, expected: `[{"id":1,"name":"a"},{"id":2,"name":"b"}]`, contentType: "application/json"},
+		{name: "empty ndjson", format: NDJSON, expected: "", contentType: "application/x-ndjson"},
+		{name: "empty array", format: JSONArray, expected: "[]", contentType: "application/json"},
+	}
+
+	for _, e := range tests {
+		rr := httptest.NewRecorder()
+		s := testTools.NewJSONStreamWriter(rr, http.StatusOK, e.format, http.Header{"X-Total": {"2"}})
+		for _, row := range e.rows {
+			if err := s.Write(row); err != nil {
+				t.Fatal(err)
+			}
+			if !rr.Flushed {
+				t.Errorf("%s: row was not flushed", e.name)
+			}
+		}
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if rr.Body.String() != e.expected {
+			t.Errorf("%s: expected %q, got %q", e.name, e.expected, rr.Body.String())
+		}
+		if rr.Header().Get("Content-Type") != e.contentType || rr.Header().Get("X-Total") != "2" {
+			t.Errorf("%s: wrong headers %v", e.name, rr.Header())
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	s := testTools.NewJSONStreamWriter(rr, http.StatusOK, JSONArray)
+	if err := s.Write(func() {}); err == nil {
+		t.Error("expected an error for a value that cannot be marshalled")
+	}
+	if rr.Body.Len() != 0 {
+		t.Error("nothing should be sent before the first value is marshalled")
+	}
+}
+
+var streamReadTests = []struct {
+	name    string
+	body    string
+	ids     []int
+	kind    JSONErrorKind
+	field   string
+	maxSize int
+}{
+	{name: "array", body: ` [{"id": 1}, {"id": 2}, {"id": 3}] `, ids: []int{1, 2, 3}},
+	{name: "ndjson", body: "{\"id\": 1}\n{\"id\": 2}\n\n{\"id\": 3}\n", ids: []int{1, 2, 3}},
+	{name: "empty array", body: `[]`},
+	{name: "empty body", body: ``},
+	{name: "type mismatch", body: `[{"id": 1}, {"id": "2"}]`, ids: []int{1}, kind: JSONTypeMismatch, field: "[1].id"},
+	{name: "unknown field", body: "{\"id\": 1}\n{\"id\": 2, \"x\": 1}", ids: []int{1}, kind: JSONUnknownField, field: "[1].x"},
+	{name: "syntax", body: `[{"id": 1}, {"id": }]`, ids: []int{1}, kind: JSONSyntax},
+	{name: "unterminated array", body: `[{"id": 1}`, ids: []int{1}, kind: JSONSyntax},
+	{name: "trailing data", body: `[{"id": 1}] {"id": 2}`, ids: []int{1}, kind: JSONTrailingData},
+	{name: "element too large", body: `[{"id": 1}, {"id": 2, "name": "` + strings.Repeat("a", 100) + `"}, {"id": 3}]`, ids: []int{1}, kind: JSONTooLarge, field: "[1]", maxSize: 64},
+	{name: "total too large", body: `[` + strings.Repeat(`{"id": 1},`, 30) + `{"id": 1}]`, ids: []int{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1}, kind: JSONTooLarge},
+}
+
+func TestTools_JSONStreamReader(t *testing.T) {
+	for _, e := range streamReadTests {
+		testTools := Tools{MaxJSONSize: e.maxSize, MaxJSONStreamSize: 200}
+		if e.maxSize == 0 {
+			testTools.MaxJSONSize = 1024
+		}
+
+		req := httptest.NewRequest("POST", "/", strings.NewReader(e.body))
+		var ids []int
+		err := ReadJSONStream(&testTools, httptest.NewRecorder(), req, func(row testRow) error {
+			ids = append(ids, row.ID)
+			return nil
+		})
+
+		if len(ids) != len(e.ids) {
+			t.Errorf("%s: expected ids %v, got %v", e.name, e.ids, ids)
+		}
+
+		if e.kind == 0 {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", e.name, err)
+			}
+			continue
+		}
+
+		var jsonErr *JSONError
+		if !errors.As(err, &jsonErr) || jsonErr.Kind != e.kind {
+			t.Errorf("%s: expected a %s error, got %v", e.name, e.kind, err)
+			continue
+		}
+		if e.field != "" && jsonErr.Field != e.field {
+			t.Errorf("%s: expected field %q, got %q", e.name, e.field, jsonErr.Field)
+		}
+	}
+}
+
+// countingReader counts the bytes read from it
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+func TestTools_JSONStreamReaderBoundedRead(t *testing.T) {
+	testTools := Tools{MaxJSONSize: 1024}
+
+	body := &countingReader{r: io.MultiReader(strings.NewReader(`[{"id": 1}, {"name": "`), strings.NewReader(strings.Repeat("a", 10<<20)))}
+	req := httptest.NewRequest("POST", "/", body)
+
+	s := testTools.NewJSONStreamReader(httptest.NewRecorder(), req)
+
+	var row testRow
+	if err := s.Decode(&row); err != nil || row.ID != 1 {
+		t.Fatalf("expected the first row, got %+v %v", row, err)
+	}
+
+	err := s.Decode(&row)
+	var jsonErr *JSONError
+	if !errors.As(err, &jsonErr) || jsonErr.Kind != JSONTooLarge || jsonErr.Status != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected a too large error, got %v", err)
+	}
+	if body.n > 64*1024 {
+		t.Errorf("read %d bytes of an oversized element", body.n)
+	}
+	if err := s.Decode(&row); err != jsonErr {
+		t.Errorf("expected the same error again, got %v", err)
+	}
+}
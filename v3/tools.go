@@ -13,6 +13,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 )
 
 const randomStringSource = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_+-"
@@ -24,6 +25,78 @@ type Tools struct {
 	AllowedFileTypes   []string
 	MaxJSONSize        int
 	AllowUnknownFields bool
+	// MaxJSONStreamSize limits the whole body read by a JSONStreamReader, in which each value is
+	// limited by MaxJSONSize. Defaults to one gigabyte.
+	MaxJSONStreamSize int
+	// Storage is where uploaded files are saved. When it is nil, files are written to the
+	// local upload directory; otherwise the upload directory is used as a key prefix.
+	Storage Storage
+	// StreamUploads makes UploadFiles read the request part by part, copying each file straight
+	// to storage instead of parsing the whole form into memory and temporary files first.
+	StreamUploads bool
+	// MaxUploadSize limits the combined size of all the files in one upload, and MaxUploadFiles
+	// limits how many files it may contain. Zero means no limit.
+	MaxUploadSize  int
+	MaxUploadFiles int
+	// ImageProcessing, when set, is applied to every uploaded JPEG, PNG, GIF or WebP image
+	ImageProcessing *ImageOptions
+	// ChecksumMD5 adds an MD5 sum to the SHA-256 sum computed for every uploaded file
+	ChecksumMD5 bool
+	// ContentAddressed saves each uploaded file under the SHA-256 sum of its content, so that a
+	// file which has been uploaded before is not stored again
+	ContentAddressed bool
+	// Scanner, when set, checks every uploaded file before it is saved. Infected files are
+	// rejected with an *InfectedFileError.
+	Scanner Scanner
+	// TransactionalUploads makes UploadFiles all or nothing: if any file in a request is rejected
+	// or fails to save, every file already saved for that request is deleted again. So that
+	// nothing is lost, a file kept under its own name is never allowed to replace an existing one;
+	// CollisionOverwrite is treated as CollisionError.
+	TransactionalUploads bool
+	// FileNameCollision decides what happens when a file uploaded without renaming has the same
+	// name as an existing file. The default, CollisionSuffix, saves it under a new name, so that
+	// two users uploading photo.jpg do not replace each other's files.
+	FileNameCollision FileNameCollision
+	// ExtractArchives, when set, makes UploadFiles save the entries of uploaded .zip and .tar.gz
+	// archives instead of the archives themselves
+	ExtractArchives *ArchiveOptions
+	// UploadFields sets rules for the files in each named form field. When it is set, files sent
+	// in any other field are rejected.
+	UploadFields map[string]FieldRule
+	// SigningKey is the secret used by SignURL and VerifySignedURL. It should be at least 32
+	// random bytes.
+	SigningKey []byte
+	// ValidateJSON makes ReadJSON check the decoded value with Validate
+	ValidateJSON bool
+	// ProblemErrors makes ErrorJSON send RFC 7807 application/problem+json responses, using
+	// ProblemJSON, in place of a JSONResponse
+	ProblemErrors bool
+	// Codecs adds formats for Write and Read to use, or replaces the built-in ones, keyed by
+	// lower case media type
+	Codecs map[string]Codec
+	// CompressMinSize is the smallest response, in bytes, that OptimizeResponses compresses.
+	// Defaults to 1024.
+	CompressMinSize int
+	// ETags makes WriteJSON, Write and ProblemJSON give 200 OK responses a strong ETag computed
+	// from the body. The handler must also be wrapped in OptimizeResponses for If-None-Match to be
+	// answered with 304 Not Modified.
+	ETags bool
+	// DefaultPageSize is the limit ReadPageParams uses when a request gives none, and MaxPageSize
+	// the largest it allows. They default to 20 and 100.
+	DefaultPageSize int
+	MaxPageSize     int
+	// EventHeartbeat is how often an EventStream sends a comment to keep its connection open.
+	// Defaults to 15 seconds; a negative value turns heartbeats off.
+	EventHeartbeat time.Duration
+	// MaxCompressedJSONSize limits the size of a request body sent with a Content-Encoding, before
+	// it is decompressed; MaxJSONSize still limits it once decompressed. Defaults to MaxJSONSize.
+	MaxCompressedJSONSize int
+	// RequireJSONContentType makes ReadJSON reject requests without a JSON Content-Type
+	RequireJSONContentType bool
+	// HTTPClient is the client DoJSON uses to call remote services. Defaults to http.DefaultClient.
+	HTTPClient *http.Client
+	// MaxRemoteJSONSize limits the response bodies DoJSON reads. Defaults to MaxJSONSize.
+	MaxRemoteJSONSize int
 }
 
 // RandomString returns a string of random character of lenght n,
@@ -39,11 +112,27 @@ func (t *Tools) RandomString(n int) string {
 	return string(s)
 }
 
-// UploadedFile is a struct used to save information about an uploaded file
+// UploadedFile is a struct used to save information about an uploaded file. Width, Height and
+// Variants are only set for images processed because of Tools.ImageProcessing, and Duplicate is
+// only set when Tools.ContentAddressed found the file already in storage. OriginalFileName is the
+// name the client sent before it was sanitized, without any directory, which mime/multipart
+// removes. For a file extracted from an archive, it is the path within the archive, and Archive
+// is the name of the archive.
 type UploadedFile struct {
 	NewFileName      string
 	OriginalFileName string
 	FileSize         int64
+	ContentType      string
+	Key              string
+	URL              string
+	Width            int
+	Height           int
+	Variants         []ImageVariant
+	SHA256           string
+	MD5              string
+	Duplicate        bool
+	Archive          string
+	FieldName        string
 }
 
 // UploadOneFile is just a convenience method that calls UploadFiles, but expects only one file to
@@ -64,100 +153,73 @@ func (t *Tools) UploadOneFile(r *http.Request, uploadDir string, rename ...bool)
 
 // UploadFiles uploads one or more file to a specified directory, and gives the files a random name.
 // It returns a slice containing the newly named files, the original file names, the size of the files,
-// and potentially an error. If the optional last parameter is set to true, then we will not rename
-// the files, but will use the original file names.
+// and potentially an error. If the optional last parameter is set to false, then we will not rename
+// the files, but will use the original file names, made safe by SanitizeFileName, and changed as
+// t.FileNameCollision decides if a file of that name already exists. If t.Storage is
+// set, the files are saved there instead, using uploadDir as the prefix of their keys. Exceeding
+// t.MaxFileSize, t.MaxUploadSize or t.MaxUploadFiles returns an *UploadLimitError. When an error
+// stops the upload part way, the files already saved are returned with it, so that the caller can
+// find or remove them, unless t.TransactionalUploads has already removed them.
 func (t *Tools) UploadFiles(r *http.Request, uploadDir string, rename ...bool) ([]*UploadedFile, error) {
 	renameFile := true
 	if len(rename) > 0 {
 		renameFile = rename[0]
 	}
 
-	var uploadedFiles []*UploadedFile
+	u, err := t.upload(r, uploadDir, renameFile)
+	if u == nil {
+		return nil, err
+	}
+
+	return u.files, err
+}
 
+// upload reads the multipart request r, saving its files according to the rules in t, and returns
+// the uploader holding the results. If it fails part way, the uploader is returned with the error,
+// holding the files saved so far, or none once a transactional upload has been rolled back.
+func (t *Tools) upload(r *http.Request, uploadDir string, renameFile bool) (*uploader, error) {
 	if t.MaxFileSize == 0 {
 		t.MaxFileSize = 1024 * 1024 * 1024
 	}
 
-	err := t.CreateDirIfNotExist(uploadDir)
+	store, prefix, err := t.uploadStorage(uploadDir)
 	if err != nil {
 		return nil, err
 	}
 
-	err = r.ParseMultipartForm(int64(t.MaxFileSize))
+	u := &uploader{t: t, ctx: r.Context(), store: store, prefix: prefix, rename: renameFile}
+
+	if t.StreamUploads {
+		err = u.readParts(r)
+	} else {
+		err = u.readForm(r)
+	}
+	if err == nil {
+		err = u.checkRequiredFields()
+	}
 	if err != nil {
-		return nil, errors.New("the uploaded file is too big")
-	}
-
-	for _, fHeaders := range r.MultipartForm.File {
-		for _, hdr := range fHeaders {
-			uploadedFiles, err = func(uploadedFiles []*UploadedFile) ([]*UploadedFile, error) {
-				var uploadedFile UploadedFile
-				infile, err := hdr.Open()
-				if err != nil {
-					return nil, err
-				}
-				defer infile.Close()
-
-				buff := make([]byte, 512)
-				_, err = infile.Read(buff)
-				if err != nil {
-					return nil, err
-				}
-
-				// check to see if the file type is permitted
-				allowed := false
-				fileType := http.DetectContentType(buff)
-
-				if len(t.AllowedFileTypes) > 0 {
-					for _, x := range t.AllowedFileTypes {
-						if strings.EqualFold(fileType, x) {
-							allowed = true
-						}
-					}
-				} else {
-					allowed = true
-				}
-
-				if !allowed {
-					return nil, errors.New("the uploaded file type is not permitted")
-				}
-
-				_, err = infile.Seek(0, 0)
-				if err != nil {
-					return nil, err
-				}
-
-				if renameFile {
-					uploadedFile.NewFileName = fmt.Sprintf("%s%s", t.RandomString(25), filepath.Ext(hdr.Filename))
-				} else {
-					uploadedFile.NewFileName = hdr.Filename
-				}
-
-				uploadedFile.OriginalFileName = hdr.Filename
-
-				var outfile *os.File
-				defer outfile.Close()
-
-				if outfile, err = os.Create(filepath.Join(uploadDir, uploadedFile.NewFileName)); err != nil {
-					return nil, err
-				} else {
-					fileSize, err := io.Copy(outfile, infile)
-					if err != nil {
-						return nil, err
-					}
-					uploadedFile.FileSize = fileSize
-				}
-
-				uploadedFiles = append(uploadedFiles, &uploadedFile)
-
-				return uploadedFiles, nil
-			}(uploadedFiles)
-			if err != nil {
-				return uploadedFiles, err
-			}
+		if t.TransactionalUploads {
+			u.rollback()
 		}
+		return u, err
+	}
+
+	return u, nil
+}
+
+// uploadStorage returns the storage that uploads should be saved to, and the key prefix to use
+// within it. Without a configured Storage, uploadDir is created and used as a LocalStorage root.
+func (t *Tools) uploadStorage(uploadDir string) (Storage, string, error) {
+	if t.Storage != nil {
+		return t.Storage, strings.Trim(filepath.ToSlash(uploadDir), "/"), nil
+	}
+
+	err := t.CreateDirIfNotExist(uploadDir)
+	if err != nil {
+		return nil, "", err
 	}
-	return uploadedFiles, nil
+
+	return &LocalStorage{Root: uploadDir}, "", nil
 }
 
 // CreateDirIfNotExist creates a directory, and all necessary parents, if it does not exist
@@ -190,27 +252,46 @@ func (t *Tools) Slugify(s string) (string, error) {
 // in the browser window by setting content disposition. It also allows specification of the
 // display name
 func (t *Tools) DownloadStaticFile(w http.ResponseWriter, r *http.Request, pathName, displayName string) {
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", displayName))
+	w.Header().Set("Content-Disposition", contentDisposition(false, displayName))
 
 	http.ServeFile(w, r, pathName)
 }
 
 type JSONResponse struct {
-	Error   bool   `json:"error"`
-	Message string `json:"message"`
-	Data    any    `json:"data,omitempty"`
+	Error   bool              `json:"error"`
+	Message string            `json:"message"`
+	Data    any               `json:"data,omitempty"`
+	Errors  map[string]string `json:"errors,omitempty"`
 }
 
 func (t *Tools) ReadJSON(w http.ResponseWriter, r *http.Request, data any) error {
-	maxBytes := 1024 * 1024 // one megabyte
+	if t.RequireJSONContentType {
+		if err := checkJSONContentType(r); err != nil {
+			return err
+		}
+	}
 
-	if t.MaxJSONSize != 0 {
-		maxBytes = t.MaxJSONSize
+	body, err := t.requestBody(w, r)
+	if err != nil {
+		return err
 	}
 
-	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+	return t.decodeJSON(body, data)
+}
+
+// maxJSONSize returns t.MaxJSONSize, or one megabyte when it is not set
+func (t *Tools) maxJSONSize() int {
+	if t.MaxJSONSize != 0 {
+		return t.MaxJSONSize
+	}
+	return 1024 * 1024 // one megabyte
+}
 
-	dec := json.NewDecoder(r.Body)
+// decodeJSON decodes the single JSON value in body into data, applying the rules of ReadJSON.
+// body must already be limited with http.MaxBytesReader. Errors in the body are returned as a
+// *JSONError.
+func (t *Tools) decodeJSON(body io.Reader, data any) error {
+	dec := json.NewDecoder(body)
 
 	if !t.AllowUnknownFields {
 		dec.DisallowUnknownFields()
@@ -218,86 +299,91 @@ func (t *Tools) ReadJSON(w http.ResponseWriter, r *http.Request, data any) error
 
 	err := dec.Decode(data)
 	if err != nil {
-		var syntaxError *json.SyntaxError
-		var unmarshalTypeError *json.UnmarshalTypeError
-		var invalidUnmarshalError *json.InvalidUnmarshalError
-
-		switch {
-		case errors.As(err, &syntaxError):
-			return fmt.Errorf("body contains badly-formed JSON (at character %d)", syntaxError.Offset)
-
-		case errors.Is(err, io.ErrUnexpectedEOF):
-			return errors.New("body contains badly-formed JSON")
-
-		case errors.As(err, &unmarshalTypeError):
-			if unmarshalTypeError.Field != "" {
-				return fmt.Errorf("body contains incorrect JSON type for field %q", unmarshalTypeError.Field)
-			}
-			return fmt.Errorf("body contains incorrect JSON type (at character %d)", unmarshalTypeError.Offset)
-
-		case errors.Is(err, io.EOF):
-			return errors.New("body must not be empty")
-
-		case strings.HasPrefix(err.Error(), "json: unknown field"):
-			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field")
-			return fmt.Errorf("body contains unknown key %s", fieldName)
-
-		case err.Error() == "http: request body too large":
-			return fmt.Errorf("body must not be larger than %d bytes", maxBytes)
-
-		case errors.As(err, &invalidUnmarshalError):
-			return fmt.Errorf("error unmarshalling JSON: %s", err.Error())
-
-		default:
-			return err
-		}
+		return newJSONError(err, dec)
 	}
 
+	offset := dec.InputOffset()
 	err = dec.Decode(&struct{}{})
 	if err != io.EOF {
-		return errors.New("body must contain single json value ")
+		var maxBytesError *http.MaxBytesError
+		var jsonErr *JSONError
+		if errors.As(err, &jsonErr) {
+			return jsonErr
+		}
+		if errors.As(err, &maxBytesError) {
+			return &JSONError{Kind: JSONTooLarge, Offset: offset, Status: http.StatusRequestEntityTooLarge, Err: err}
+		}
+		return &JSONError{Kind: JSONTrailingData, Offset: offset, Status: http.StatusBadRequest, Err: err}
+	}
+
+	if t.ValidateJSON {
+		return t.Validate(data)
 	}
 
 	return nil
 }
 
+// WriteJSON sends data to the client as JSON with status, after any extra headers. When t.ETags
+// is set, a 200 OK response gets an ETag, but only a handler wrapped in OptimizeResponses answers
+// a matching If-None-Match with 304 Not Modified, or compresses the response; WriteJSON on its own
+// always sends the whole body.
 func (t *Tools) WriteJSON(w http.ResponseWriter, status int, data any, headers ...http.Header) error {
 	out, err := json.Marshal(data)
 	if err != nil {
 		return err
 	}
 
-	if len(headers) > 0 {
-		for key, value := range headers[0] {
-			w.Header()[key] = value
-		}
-	}
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	_, err = w.Write(out)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return t.send(w, status, "application/json", out, headers...)
 }
 
+// ErrorJSON sends err to the client as a JSONResponse, or as a Problem when t.ProblemErrors is
+// set. The status code defaults to 400 Bad Request, or to the status of a *JSONError,
+// *DecodeError or *PatchError from ReadJSON, Read or the patch readers, or of a *Problem.
 func (t *Tools) ErrorJSON(w http.ResponseWriter, err error, status ...int) error {
 	statusCode := http.StatusBadRequest
 
+	var jsonErr *JSONError
+	var decodeErr *DecodeError
+	var patchErr *PatchError
+	var problem *Problem
+	var remoteErr *RemoteError
+	switch {
+	case errors.As(err, &jsonErr) && jsonErr.Status != 0:
+		statusCode = jsonErr.Status
+	case errors.As(err, &decodeErr) && decodeErr.Status != 0:
+		statusCode = decodeErr.Status
+	case errors.As(err, &patchErr) && patchErr.Status != 0:
+		statusCode = patchErr.Status
+	case errors.As(err, &problem) && problem.Status != 0:
+		statusCode = problem.Status
+	case errors.As(err, &remoteErr):
+		statusCode = http.StatusBadGateway
+	}
+
 	if len(status) > 0 {
 		statusCode = status[0]
 	}
 
+	if t.ProblemErrors {
+		return t.ProblemJSON(w, problemFromError(err, statusCode))
+	}
+
 	var payload JSONResponse
 	payload.Error = true
 	payload.Message = err.Error()
 
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		payload.Message = "validation failed"
+		payload.Errors = validationErr.Fields()
+	}
+
 	return t.WriteJSON(w, statusCode, payload)
 }
 
 // PushJSONToRemote posts arbitrary data to some URL as JSON, and returns the response, status code, and error, if any.
 // The final parameter, client, is optional. If none is specified, we use the standard http.Client.
+// The body of the response has already been closed; use DoJSON to read what the remote sent back.
 func (t *Tools) PushJSONToRemote(uri string, data interface{}, client ...*http.Client) (*http.Response, int, error) {
 	// create json
 	jsonData, err := json.Marshal(data)
@@ -0,0 +1,438 @@
+package gotoolkit
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination"
+)
+
+// TusHandler is an http.Handler implementing the tus 1.0 resumable upload protocol, with the
+// creation and termination extensions. Partial uploads are kept in TempDir until they are
+// complete; a complete upload is checked against the MaxFileSize and AllowedFileTypes of Tools,
+// saved in the same place UploadFiles would save it for UploadDir, and passed to OnComplete. An
+// archive extracted because of Tools.ExtractArchives calls OnComplete once for each entry.
+//
+// BasePath is the path the handler is mounted at, such as "/files/"; upload URLs are BasePath
+// followed by the upload id. The file name is taken from the "filename" metadata sent by the
+// client, and is replaced with a random name unless KeepFileNames is set.
+type TusHandler struct {
+	Tools         *Tools
+	BasePath      string
+	UploadDir     string
+	TempDir       string
+	KeepFileNames bool
+	OnComplete    func(r *http.Request, file *UploadedFile)
+
+	locks sync.Map
+}
+
+// tusInfo is saved next to the data of every partial upload
+type tusInfo struct {
+	ID       string            `json:"id"`
+	Length   int64             `json:"length"`
+	Metadata string            `json:"metadata,omitempty"`
+	Values   map[string]string `json:"values,omitempty"`
+	Checked  bool              `json:"checked"`
+}
+
+func (h *TusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	method := r.Method
+	if override := r.Header.Get("X-HTTP-Method-Override"); override != "" {
+		method = strings.ToUpper(override)
+	}
+
+	w.Header().Set("Tus-Resumable", tusVersion)
+
+	if method == http.MethodOptions {
+		w.Header().Set("Tus-Version", tusVersion)
+		w.Header().Set("Tus-Extension", tusExtensions)
+		w.Header().Set("Tus-Max-Size", strconv.FormatInt(h.maxSize(), 10))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		http.Error(w, "unsupported tus version", http.StatusPreconditionFailed)
+		return
+	}
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, h.BasePath), "/")
+
+	switch {
+	case method == http.MethodPost && id == "":
+		h.create(w, r)
+	case method == http.MethodHead && id != "":
+		h.head(w, r, id)
+	case method == http.MethodPatch && id != "":
+		h.patch(w, r, id)
+	case method == http.MethodDelete && id != "":
+		h.terminate(w, r, id)
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *TusHandler) tools() *Tools {
+	if h.Tools != nil {
+		return h.Tools
+	}
+	return &Tools{}
+}
+
+func (h *TusHandler) maxSize() int64 {
+	if h.tools().MaxFileSize > 0 {
+		return int64(h.tools().MaxFileSize)
+	}
+	return 1024 * 1024 * 1024
+}
+
+func (h *TusHandler) tempDir() string {
+	if h.TempDir != "" {
+		return h.TempDir
+	}
+	return filepath.Join(os.TempDir(), "tus-uploads")
+}
+
+// paths returns the data and info file names for the upload id. Ids are always generated by
+// create as hex strings, so anything else cannot be a valid upload.
+func (h *TusHandler) paths(id string) (string, string, bool) {
+	if len(id) != 32 {
+		return "", "", false
+	}
+	if _, err := hex.DecodeString(id); err != nil {
+		return "", "", false
+	}
+
+	base := filepath.Join(h.tempDir(), id)
+	return base + ".bin", base + ".info", true
+}
+
+// lock serialises requests for the same upload, so that two PATCH requests cannot interleave
+func (h *TusHandler) lock(id string) func() {
+	v, _ := h.locks.LoadOrStore(id, &sync.Mutex{})
+	mu := v.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+func (h *TusHandler) readInfo(id string) (*tusInfo, string, int64, error) {
+	dataPath, infoPath, ok := h.paths(id)
+	if !ok {
+		return nil, "", 0, os.ErrNotExist
+	}
+
+	b, err := os.ReadFile(infoPath)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	var info tusInfo
+	if err := json.Unmarshal(b, &info); err != nil {
+		return nil, "", 0, err
+	}
+
+	fi, err := os.Stat(dataPath)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	return &info, dataPath, fi.Size(), nil
+}
+
+func (h *TusHandler) writeInfo(info *tusInfo) error {
+	_, infoPath, _ := h.paths(info.ID)
+
+	b, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(infoPath, b, 0644)
+}
+
+func (h *TusHandler) remove(id string) {
+	dataPath, infoPath, ok := h.paths(id)
+	if !ok {
+		return
+	}
+	_ = os.Remove(dataPath)
+	_ = os.Remove(infoPath)
+	h.locks.Delete(id)
+}
+
+func (h *TusHandler) create(w http.ResponseWriter, r *http.Request) {
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "missing or invalid Upload-Length header", http.StatusBadRequest)
+		return
+	}
+	if length > h.maxSize() {
+		http.Error(w, (&UploadLimitError{Limit: LimitFileSize, Max: h.maxSize()}).Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	values, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	info := &tusInfo{ID: hex.EncodeToString(b), Length: length, Metadata: r.Header.Get("Upload-Metadata"), Values: values}
+
+	if err := h.tools().CreateDirIfNotExist(h.tempDir()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	dataPath, _, _ := h.paths(info.ID)
+	f, err := os.Create(dataPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	f.Close()
+
+	if err := h.writeInfo(info); err != nil {
+		h.remove(info.ID)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", strings.TrimSuffix(h.BasePath, "/")+"/"+info.ID)
+
+	if length == 0 {
+		if status, err := h.finish(r, info, dataPath); err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (h *TusHandler) head(w http.ResponseWriter, r *http.Request, id string) {
+	defer h.lock(id)()
+
+	info, _, offset, err := h.readInfo(id)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(info.Length, 10))
+	if info.Metadata != "" {
+		w.Header().Set("Upload-Metadata", info.Metadata)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *TusHandler) patch(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "Content-Type must be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	defer h.lock(id)()
+
+	info, dataPath, offset, err := h.readInfo(id)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	requestOffset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		http.Error(w, "missing or invalid Upload-Offset header", http.StatusBadRequest)
+		return
+	}
+	if requestOffset != offset {
+		http.Error(w, "Upload-Offset does not match the current offset", http.StatusConflict)
+		return
+	}
+
+	f, err := os.OpenFile(dataPath, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// whatever arrives is kept, even if the connection drops part way through, so that the
+	// client can resume from the new offset
+	remaining := info.Length - offset
+	n, copyErr := io.Copy(f, io.LimitReader(r.Body, remaining))
+	f.Close()
+	offset += n
+
+	if copyErr == nil && n == remaining {
+		if extra, _ := r.Body.Read(make([]byte, 1)); extra > 0 {
+			http.Error(w, "the upload is larger than its Upload-Length", http.StatusRequestEntityTooLarge)
+			return
+		}
+	}
+
+	if !info.Checked && (offset >= 512 || offset == info.Length) {
+		status, err := h.checkType(info, dataPath)
+		if err != nil {
+			h.remove(id)
+			http.Error(w, err.Error(), status)
+			return
+		}
+	}
+
+	if copyErr != nil {
+		http.Error(w, copyErr.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if offset == info.Length {
+		if status, err := h.finish(r, info, dataPath); err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkType rejects an upload as soon as enough of it has arrived to detect its type, rather than
+// waiting for the whole file. An archive is let through when Tools.ExtractArchives is set, since
+// its entries are checked once it is complete.
+func (h *TusHandler) checkType(info *tusInfo, dataPath string) (int, error) {
+	f, err := os.Open(dataPath)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	defer f.Close()
+
+	buff := make([]byte, 512)
+	n, err := io.ReadFull(f, buff)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return http.StatusInternalServerError, err
+	}
+
+	t := h.tools()
+	fileType := http.DetectContentType(buff[:n])
+	if !fileTypeAllowed(t.AllowedFileTypes, fileType) && (t.ExtractArchives == nil || !isArchive(fileType)) {
+		return http.StatusUnsupportedMediaType, ErrFileTypeNotPermitted
+	}
+
+	info.Checked = true
+	if err := h.writeInfo(info); err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	return 0, nil
+}
+
+// finish saves a complete upload to its destination, and hands it to OnComplete. An upload that
+// breaks the upload rules is removed; one that fails to save is kept, so that an empty PATCH at
+// the final offset can try again.
+func (h *TusHandler) finish(r *http.Request, info *tusInfo, dataPath string) (int, error) {
+	t := h.tools()
+
+	store, prefix, err := t.uploadStorage(h.UploadDir)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	f, err := os.Open(dataPath)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	defer f.Close()
+
+	fileName := info.Values["filename"]
+	if fileName == "" {
+		fileName = info.ID
+	}
+
+	u := &uploader{t: t, ctx: r.Context(), store: store, prefix: prefix, rename: !h.KeepFileNames}
+	err = u.save(&uploadPart{fileName: fileName, reader: f, size: info.Length})
+	if err != nil {
+		u.rollback()
+
+		var limitErr *UploadLimitError
+		switch {
+		case errors.As(err, &limitErr):
+			h.remove(info.ID)
+			return http.StatusRequestEntityTooLarge, err
+		case errors.Is(err, ErrFileTypeNotPermitted):
+			h.remove(info.ID)
+			return http.StatusUnsupportedMediaType, err
+		default:
+			return http.StatusInternalServerError, err
+		}
+	}
+
+	h.remove(info.ID)
+
+	if h.OnComplete != nil {
+		for _, file := range u.files {
+			h.OnComplete(r, file)
+		}
+	}
+
+	return 0, nil
+}
+
+func (h *TusHandler) terminate(w http.ResponseWriter, r *http.Request, id string) {
+	defer h.lock(id)()
+
+	if _, _, _, err := h.readInfo(id); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	h.remove(id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseTusMetadata decodes an Upload-Metadata header, a comma separated list of keys each
+// followed by an optional base64 encoded value
+func parseTusMetadata(header string) (map[string]string, error) {
+	values := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return values, nil
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		switch len(fields) {
+		case 1:
+			values[fields[0]] = ""
+		case 2:
+			v, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				return nil, errors.New("invalid Upload-Metadata header")
+			}
+			values[fields[0]] = string(v)
+		default:
+			return nil, errors.New("invalid Upload-Metadata header")
+		}
+	}
+
+	return values, nil
+}
@@ -0,0 +1,199 @@
+package gotoolkit
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func tusRequest(t *testing.T, method, url string, body []byte, headers map[string]string) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Tus-Resumable", "1.0.0")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	return res
+}
+
+func TestTusHandler(t *testing.T) {
+	var completed *UploadedFile
+	store := &MemoryStorage{}
+
+	handler := &TusHandler{
+		Tools:         &Tools{Storage: store, AllowedFileTypes: []string{"image/png"}},
+		BasePath:      "/files/",
+		UploadDir:     "photos",
+		TempDir:       t.TempDir(),
+		KeepFileNames: true,
+		OnComplete: func(r *http.Request, file *UploadedFile) {
+			completed = file
+		},
+	}
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	data := readTestFile(t, "./testdata/img.png")
+
+	res := tusRequest(t, http.MethodOptions, srv.URL+"/files/", nil, nil)
+	if res.StatusCode != http.StatusNoContent || res.Header.Get("Tus-Extension") != "creation,termination" {
+		t.Fatalf("unexpected OPTIONS response: %d %v", res.StatusCode, res.Header)
+	}
+
+	res = tusRequest(t, http.MethodPost, srv.URL+"/files/", nil, map[string]string{"Tus-Resumable": "0.2.2"})
+	if res.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("expected 412 for unsupported version, got %d", res.StatusCode)
+	}
+
+	res = tusRequest(t, http.MethodPost, srv.URL+"/files/", nil, map[string]string{
+		"Upload-Length":   strconv.Itoa(len(data)),
+		"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte("house.png")),
+	})
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201 from creation, got %d", res.StatusCode)
+	}
+	location := srv.URL + res.Header.Get("Location")
+
+	// send the first half, then pretend the connection dropped and resume from HEAD
+	half := len(data) / 2
+	res = tusRequest(t, http.MethodPatch, location, data[:half], map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": "0",
+	})
+	if res.StatusCode != http.StatusNoContent || res.Header.Get("Upload-Offset") != strconv.Itoa(half) {
+		t.Fatalf("unexpected PATCH response: %d offset %s", res.StatusCode, res.Header.Get("Upload-Offset"))
+	}
+
+	res = tusRequest(t, http.MethodPatch, location, data[half:], map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": "0",
+	})
+	if res.StatusCode != http.StatusConflict {
+		t.Errorf("expected 409 for the wrong offset, got %d", res.StatusCode)
+	}
+
+	res = tusRequest(t, http.MethodHead, location, nil, nil)
+	offset := res.Header.Get("Upload-Offset")
+
+	res = tusRequest(t, http.MethodPatch, location, data[half:], map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": offset,
+	})
+	if res.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected 204 from final PATCH, got %d", res.StatusCode)
+	}
+
+	if completed == nil {
+		t.Fatal("expected OnComplete to be called")
+	}
+	if completed.Key != "photos/house.png" || completed.FileSize != int64(len(data)) {
+		t.Errorf("unexpected completed file: %+v", completed)
+	}
+	if _, err := store.Stat(context.Background(), "photos/house.png"); err != nil {
+		t.Errorf("expected upload to be in storage: %s", err)
+	}
+
+	res = tusRequest(t, http.MethodHead, location, nil, nil)
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("expected finished upload to be gone, got %d", res.StatusCode)
+	}
+}
+
+func TestTusHandler_ExtractsArchive(t *testing.T) {
+	var completed []string
+	store := &MemoryStorage{}
+
+	handler := &TusHandler{
+		Tools:         &Tools{Storage: store, AllowedFileTypes: []string{"image/png"}, ExtractArchives: &ArchiveOptions{}},
+		BasePath:      "/files/",
+		UploadDir:     "photos",
+		TempDir:       t.TempDir(),
+		KeepFileNames: true,
+		OnComplete: func(r *http.Request, file *UploadedFile) {
+			completed = append(completed, file.Key)
+		},
+	}
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	img := readTestFile(t, "./testdata/img.png")
+	data := zipArchive(t, testEntry{name: "front.png", data: img}, testEntry{name: "back.png", data: img})
+
+	res := tusRequest(t, http.MethodPost, srv.URL+"/files/", nil, map[string]string{
+		"Upload-Length":   strconv.Itoa(len(data)),
+		"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte("house.zip")),
+	})
+	location := srv.URL + res.Header.Get("Location")
+
+	res = tusRequest(t, http.MethodPatch, location, data, map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": "0",
+	})
+	if res.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected 204 from PATCH, got %d", res.StatusCode)
+	}
+
+	if len(completed) != 2 || completed[0] != "photos/front.png" || completed[1] != "photos/back.png" {
+		t.Errorf("expected OnComplete for each entry, got %v", completed)
+	}
+	if len(store.Keys()) != 2 {
+		t.Errorf("expected only the entries in storage, got %v", store.Keys())
+	}
+}
+
+func TestTusHandler_RejectsTypeAndTerminates(t *testing.T) {
+	handler := &TusHandler{
+		Tools:    &Tools{Storage: &MemoryStorage{}, AllowedFileTypes: []string{"image/png"}},
+		BasePath: "/files/",
+		TempDir:  t.TempDir(),
+	}
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	text := bytes.Repeat([]byte("plain text "), 100)
+
+	res := tusRequest(t, http.MethodPost, srv.URL+"/files/", nil, map[string]string{"Upload-Length": strconv.Itoa(len(text))})
+	location := srv.URL + res.Header.Get("Location")
+
+	res = tusRequest(t, http.MethodPatch, location, text, map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": "0",
+	})
+	if res.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("expected 415 for a disallowed type, got %d", res.StatusCode)
+	}
+
+	res = tusRequest(t, http.MethodPost, srv.URL+"/files/", nil, map[string]string{"Upload-Length": "10"})
+	location = srv.URL + res.Header.Get("Location")
+
+	res = tusRequest(t, http.MethodDelete, location, nil, nil)
+	if res.StatusCode != http.StatusNoContent {
+		t.Errorf("expected 204 from termination, got %d", res.StatusCode)
+	}
+
+	res = tusRequest(t, http.MethodHead, location, nil, nil)
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("expected terminated upload to be gone, got %d", res.StatusCode)
+	}
+
+	handler.Tools.MaxFileSize = 5
+	res = tusRequest(t, http.MethodPost, srv.URL+"/files/", nil, map[string]string{"Upload-Length": "10"})
+	if res.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 for an upload over MaxFileSize, got %d", res.StatusCode)
+	}
+}
@@ -0,0 +1,489 @@
+package gotoolkit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ErrFileTypeNotPermitted is returned when the detected type of an uploaded file is not one of
+// Tools.AllowedFileTypes
+var ErrFileTypeNotPermitted = errors.New("the uploaded file type is not permitted")
+
+// UploadLimit identifies one of the limits applied to uploads
+type UploadLimit int
+
+const (
+	// LimitFileSize is the size of a single file, set by Tools.MaxFileSize
+	LimitFileSize UploadLimit = iota + 1
+	// LimitTotalSize is the combined size of all files in a request, set by Tools.MaxUploadSize
+	LimitTotalSize
+	// LimitFileCount is the number of files in a request, set by Tools.MaxUploadFiles
+	LimitFileCount
+)
+
+func (l UploadLimit) String() string {
+	switch l {
+	case LimitFileSize:
+		return "file size"
+	case LimitTotalSize:
+		return "total size"
+	case LimitFileCount:
+		return "file count"
+	default:
+		return fmt.Sprintf("UploadLimit(%d)", int(l))
+	}
+}
+
+// UploadLimitError is returned when an upload exceeds one of the configured limits
+type UploadLimitError struct {
+	Limit    UploadLimit
+	Max      int64
+	FileName string
+}
+
+func (e *UploadLimitError) Error() string {
+	switch e.Limit {
+	case LimitFileSize:
+		return fmt.Sprintf("the uploaded file is too big: %s is larger than %d bytes", e.FileName, e.Max)
+	case LimitTotalSize:
+		return fmt.Sprintf("the upload is too big: the files are larger than %d bytes in total", e.Max)
+	case LimitFileCount:
+		return fmt.Sprintf("the upload contains too many files: no more than %d are permitted", e.Max)
+	default:
+		return fmt.Sprintf("the upload exceeds the %s limit of %d", e.Limit, e.Max)
+	}
+}
+
+// uploadPart is a single file taken from a multipart request, whether the request was parsed
+// up front or is being streamed
+type uploadPart struct {
+	field    string
+	fileName string
+	header   textproto.MIMEHeader
+	reader   io.Reader
+	size     int64
+	// fromArchive is set for the entries of an extracted archive, which are never extracted again
+	fromArchive bool
+}
+
+// uploader holds the state of a single call to UploadFiles
+type uploader struct {
+	t      *Tools
+	ctx    context.Context
+	store  Storage
+	prefix string
+	rename bool
+	files  []*UploadedFile
+	total  int64
+	// committed holds the key of every object this upload has created, so that they can be
+	// removed again by rollback
+	committed []string
+	// fieldCounts holds the number of files saved from each form field, and values the plain
+	// form values
+	fieldCounts map[string]int
+	values      url.Values
+	valueBytes  int64
+}
+
+// readForm parses the whole multipart form with ParseMultipartForm, and then saves each file
+func (u *uploader) readForm(r *http.Request) error {
+	err := r.ParseMultipartForm(int64(u.t.MaxFileSize))
+	if err != nil {
+		return errors.New("the uploaded file is too big")
+	}
+	u.values = url.Values(r.MultipartForm.Value)
+
+	for field, fHeaders := range r.MultipartForm.File {
+		for _, hdr := range fHeaders {
+			err = func() error {
+				infile, err := hdr.Open()
+				if err != nil {
+					return err
+				}
+				defer infile.Close()
+
+				return u.save(&uploadPart{field: field, fileName: hdr.Filename, header: hdr.Header, reader: infile, size: hdr.Size})
+			}()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// readParts reads the request with a multipart.Reader, and copies each file part to storage as it
+// arrives, so that nothing but the part currently being copied is held in memory
+func (u *uploader) readParts(r *http.Request) error {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return err
+	}
+
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if part.FileName() == "" {
+			err = u.addValue(part.FormName(), part)
+		} else {
+			err = u.save(&uploadPart{field: part.FormName(), fileName: part.FileName(), header: part.Header, reader: part, size: -1})
+		}
+		part.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// save checks a single file against the upload rules, and writes it to storage
+func (u *uploader) save(p *uploadPart) error {
+	t := u.t
+
+	if t.MaxUploadFiles > 0 && len(u.files) >= t.MaxUploadFiles {
+		return &UploadLimitError{Limit: LimitFileCount, Max: int64(t.MaxUploadFiles), FileName: p.fileName}
+	}
+
+	rule, err := u.fieldRule(p.field)
+	if err != nil {
+		return err
+	}
+	if rule != nil && rule.MaxFiles > 0 && u.fieldCounts[p.field] >= rule.MaxFiles {
+		return &UploadFieldError{Field: p.field, Reason: fmt.Sprintf("accepts no more than %d files", rule.MaxFiles)}
+	}
+
+	if p.size >= 0 {
+		if err := u.checkSize(p.fileName, p.size, p.size); err != nil {
+			return err
+		}
+	}
+
+	buff := make([]byte, 512)
+	n, err := io.ReadFull(p.reader, buff)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	buff = buff[:n]
+
+	fileType := http.DetectContentType(buff)
+
+	// archives are replaced by their entries, which are checked one by one
+	if t.ExtractArchives != nil && !p.fromArchive && isArchive(fileType) {
+		archive := &limitedUploadReader{u: u, r: io.MultiReader(bytes.NewReader(buff), p.reader), fileName: p.fileName, fileOnly: true}
+		return u.extract(p, archive, fileType)
+	}
+
+	// check to see if the file type is permitted
+	allowedTypes := t.AllowedFileTypes
+	if rule != nil && len(rule.AllowedFileTypes) > 0 {
+		allowedTypes = rule.AllowedFileTypes
+	}
+	if !fileTypeAllowed(allowedTypes, fileType) {
+		return ErrFileTypeNotPermitted
+	}
+
+	var uploadedFile UploadedFile
+	safeName := t.SanitizeFileName(p.fileName)
+	if u.rename {
+		uploadedFile.NewFileName = fmt.Sprintf("%s%s", t.RandomString(25), filepath.Ext(safeName))
+	} else {
+		uploadedFile.NewFileName = safeName
+	}
+	uploadedFile.OriginalFileName = p.fileName
+	uploadedFile.ContentType = fileType
+
+	// the checksums are always of the file as it was received, before any processing
+	hashes := newUploadHashes(t.ChecksumMD5 || p.header.Get("Content-Md5") != "")
+	limited := &limitedUploadReader{u: u, r: io.MultiReader(bytes.NewReader(buff), p.reader), fileName: p.fileName}
+	var in io.Reader = io.TeeReader(limited, hashes)
+	size := p.size
+	key := path.Join(u.prefix, uploadedFile.NewFileName)
+
+	// some steps need the whole file before it can be saved: images are processed as a whole, so
+	// they are read into memory; content addressed, scanned and checksummed files are spooled to a
+	// temporary file
+	var img image.Image
+	isImage := t.ImageProcessing != nil && isProcessableImage(fileType)
+	hasChecksum := p.header.Get("X-Checksum-Sha256") != "" || p.header.Get("Content-Md5") != ""
+	readAll := isImage || t.ContentAddressed || t.Scanner != nil || hasChecksum
+	if readAll {
+		var whole io.ReadSeeker
+		if isImage {
+			data, err := io.ReadAll(in)
+			if err != nil {
+				return err
+			}
+			whole, size = bytes.NewReader(data), int64(len(data))
+		} else {
+			spool, n, err := spoolTemp(in)
+			if err != nil {
+				return err
+			}
+			defer removeTemp(spool)
+			whole, size = spool, n
+		}
+		in = whole
+
+		uploadedFile.SHA256, uploadedFile.MD5 = hashes.sums()
+		if err := verifyChecksums(p.header, p.fileName, hashes); err != nil {
+			return err
+		}
+
+		if t.Scanner != nil {
+			if err := u.scan(p.fileName, whole); err != nil {
+				return err
+			}
+		}
+
+		if isImage {
+			data, err := io.ReadAll(whole)
+			if err != nil {
+				return err
+			}
+
+			data, img, err = processImage(t.ImageProcessing, p.fileName, data)
+			if err != nil {
+				return err
+			}
+
+			in, size = bytes.NewReader(data), int64(len(data))
+			uploadedFile.Width, uploadedFile.Height = img.Bounds().Dx(), img.Bounds().Dy()
+		}
+	}
+
+	var obj *StorageObject
+	if t.ContentAddressed {
+		uploadedFile.NewFileName = uploadedFile.SHA256 + strings.ToLower(filepath.Ext(safeName))
+		key = path.Join(u.prefix, uploadedFile.NewFileName)
+
+		obj, err = u.store.Stat(u.ctx, key)
+		if err == nil {
+			uploadedFile.Duplicate = true
+		} else if !errors.Is(err, ErrObjectNotFound) {
+			return err
+		}
+	} else if !u.rename {
+		key, err = t.resolveCollision(u.ctx, u.store, key)
+		if err != nil {
+			return err
+		}
+		uploadedFile.NewFileName = path.Base(key)
+	}
+
+	if !uploadedFile.Duplicate {
+		obj, err = u.store.Put(u.ctx, key, in, size, fileType)
+		if err != nil {
+			var limitErr *UploadLimitError
+			if errors.As(err, &limitErr) {
+				return limitErr
+			}
+			return err
+		}
+		u.committed = append(u.committed, obj.Key)
+	}
+
+	if !readAll {
+		uploadedFile.SHA256, uploadedFile.MD5 = hashes.sums()
+	}
+
+	uploadedFile.FileSize = obj.Size
+	uploadedFile.Key = obj.Key
+	uploadedFile.URL = obj.URL
+
+	if img != nil {
+		if err := u.saveThumbnails(&uploadedFile, img); err != nil {
+			return err
+		}
+	}
+
+	uploadedFile.FieldName = p.field
+	u.files = append(u.files, &uploadedFile)
+	if u.fieldCounts == nil {
+		u.fieldCounts = make(map[string]int)
+	}
+	u.fieldCounts[p.field]++
+
+	return nil
+}
+
+// saveThumbnails generates and saves every thumbnail in t.ImageProcessing for the uploaded image.
+// A duplicate found by content addressing shares the thumbnails saved with the earlier upload,
+// which are only reported, and are left out of committed as they belong to that upload.
+func (u *uploader) saveThumbnails(uploadedFile *UploadedFile, img image.Image) error {
+	opts := u.t.ImageProcessing
+	format, ext := thumbnailFormat(uploadedFile.ContentType)
+
+	for _, thumb := range opts.Thumbnails {
+		key := thumbnailKey(uploadedFile.Key, thumb.Name, ext)
+
+		if uploadedFile.Duplicate {
+			obj, err := u.store.Stat(u.ctx, key)
+			if err == nil {
+				width, height := fitSize(img.Bounds().Dx(), img.Bounds().Dy(), thumb.Width, thumb.Height)
+				uploadedFile.Variants = append(uploadedFile.Variants, ImageVariant{
+					Name:     thumb.Name,
+					Key:      obj.Key,
+					URL:      obj.URL,
+					Width:    width,
+					Height:   height,
+					FileSize: obj.Size,
+				})
+				continue
+			}
+			if !errors.Is(err, ErrObjectNotFound) {
+				return err
+			}
+		}
+
+		resized := resizeToFit(img, thumb.Width, thumb.Height)
+
+		var buf bytes.Buffer
+		if err := encodeImage(&buf, format, resized, opts.JPEGQuality); err != nil {
+			return err
+		}
+
+		obj, err := u.store.Put(u.ctx, key, &buf, int64(buf.Len()), "image/"+format)
+		if err != nil {
+			return err
+		}
+
+		u.committed = append(u.committed, obj.Key)
+
+		uploadedFile.Variants = append(uploadedFile.Variants, ImageVariant{
+			Name:     thumb.Name,
+			Key:      obj.Key,
+			URL:      obj.URL,
+			Width:    resized.Bounds().Dx(),
+			Height:   resized.Bounds().Dy(),
+			FileSize: obj.Size,
+		})
+	}
+
+	return nil
+}
+
+// rollback deletes every object saved so far by this upload. Files that content addressing found
+// were already stored, and their thumbnails, are left alone, as they belong to earlier uploads.
+func (u *uploader) rollback() {
+	// the request may have been cancelled, which is often why the upload failed
+	ctx := context.Background()
+
+	for i := len(u.committed) - 1; i >= 0; i-- {
+		_ = u.store.Delete(ctx, u.committed[i])
+	}
+	u.committed = nil
+	u.files = nil
+}
+
+// scan runs t.Scanner over a file that has been read in full, and rewinds it afterwards
+func (u *uploader) scan(fileName string, r io.ReadSeeker) error {
+	signature, err := u.t.Scanner.Scan(u.ctx, r)
+	if err != nil {
+		return err
+	}
+	if signature != "" {
+		return &InfectedFileError{FileName: fileName, Signature: signature}
+	}
+
+	_, err = r.Seek(0, io.SeekStart)
+	return err
+}
+
+// checkSize returns an *UploadLimitError if a file of fileSize bytes, bringing the upload to
+// total bytes, exceeds the per file or per request limits
+func (u *uploader) checkSize(fileName string, fileSize, total int64) error {
+	t := u.t
+
+	if t.MaxFileSize > 0 && fileSize > int64(t.MaxFileSize) {
+		return &UploadLimitError{Limit: LimitFileSize, Max: int64(t.MaxFileSize), FileName: fileName}
+	}
+	if t.MaxUploadSize > 0 && u.total+total > int64(t.MaxUploadSize) {
+		return &UploadLimitError{Limit: LimitTotalSize, Max: int64(t.MaxUploadSize), FileName: fileName}
+	}
+
+	return nil
+}
+
+// fileTypeAllowed reports whether fileType is one of allowedTypes. Any type is allowed when the
+// list is empty.
+func fileTypeAllowed(allowedTypes []string, fileType string) bool {
+	if len(allowedTypes) == 0 {
+		return true
+	}
+
+	for _, x := range allowedTypes {
+		if strings.EqualFold(fileType, x) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// limitedUploadReader counts the bytes of a file as they are read, and fails with an
+// *UploadLimitError as soon as the file or the request grows past its limit. With fileOnly set,
+// the bytes are not counted towards the size of the request, as for an archive whose entries
+// will be counted instead.
+type limitedUploadReader struct {
+	u        *uploader
+	r        io.Reader
+	fileName string
+	fileOnly bool
+	n        int64
+}
+
+func (l *limitedUploadReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if !l.fileOnly {
+		l.u.total += int64(n)
+	}
+
+	if limitErr := l.u.checkSize(l.fileName, l.n, 0); limitErr != nil {
+		return n, limitErr
+	}
+
+	return n, err
+}
+
+// spoolTemp copies r to a temporary file, and returns the file ready to be read from the start
+func spoolTemp(r io.Reader) (*os.File, int64, error) {
+	tmp, err := os.CreateTemp("", "upload-*")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	n, err := io.Copy(tmp, r)
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		removeTemp(tmp)
+		return nil, 0, err
+	}
+
+	return tmp, n, nil
+}
+
+// removeTemp closes and deletes a temporary file
+func removeTemp(f *os.File) {
+	_ = f.Close()
+	_ = os.Remove(f.Name())
+}
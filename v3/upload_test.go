@@ -0,0 +1,87 @@
+package gotoolkit
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+var uploadLimitTests = []struct {
+	name          string
+	stream        bool
+	maxFileSize   int
+	maxUploadSize int
+	maxFiles      int
+	expectedLimit UploadLimit
+}{
+	{name: "stream within limits", stream: true, maxFileSize: 1024, maxUploadSize: 2048, maxFiles: 2},
+	{name: "stream file too big", stream: true, maxFileSize: 600, expectedLimit: LimitFileSize},
+	{name: "stream upload too big", stream: true, maxFileSize: 1024, maxUploadSize: 1500, expectedLimit: LimitTotalSize},
+	{name: "stream too many files", stream: true, maxFiles: 1, expectedLimit: LimitFileCount},
+	{name: "form within limits", maxFileSize: 1024, maxUploadSize: 2048, maxFiles: 2},
+	{name: "form file too big", maxFileSize: 600, expectedLimit: LimitFileSize},
+	{name: "form upload too big", maxFileSize: 1024, maxUploadSize: 1500, expectedLimit: LimitTotalSize},
+	{name: "form too many files", maxFiles: 1, expectedLimit: LimitFileCount},
+}
+
+func TestTools_UploadFilesLimits(t *testing.T) {
+	for _, e := range uploadLimitTests {
+		request := multipartRequest(t,
+			testPart{field: "title", data: []byte("a title")},
+			testPart{field: "file", fileName: "one.txt", data: bytes.Repeat([]byte("a"), 800)},
+			testPart{field: "file", fileName: "two.txt", data: bytes.Repeat([]byte("b"), 800)},
+		)
+
+		store := &MemoryStorage{}
+		testTools := Tools{
+			Storage:        store,
+			StreamUploads:  e.stream,
+			MaxFileSize:    e.maxFileSize,
+			MaxUploadSize:  e.maxUploadSize,
+			MaxUploadFiles: e.maxFiles,
+		}
+
+		uploadedFiles, err := testTools.UploadFiles(request, "docs")
+
+		if e.expectedLimit == 0 {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %s", e.name, err)
+			} else if len(uploadedFiles) != 2 {
+				t.Errorf("%s: expected 2 files, got %d", e.name, len(uploadedFiles))
+			}
+			continue
+		}
+
+		var limitErr *UploadLimitError
+		if !errors.As(err, &limitErr) {
+			t.Errorf("%s: expected *UploadLimitError, got %v", e.name, err)
+			continue
+		}
+		if limitErr.Limit != e.expectedLimit {
+			t.Errorf("%s: expected %s limit, got %s", e.name, e.expectedLimit, limitErr.Limit)
+		}
+
+		// the file that broke a size limit must not be left in storage
+		if e.expectedLimit != LimitFileCount && len(store.Keys()) > 1 {
+			t.Errorf("%s: partially uploaded file left in storage: %v", e.name, store.Keys())
+		}
+	}
+}
+
+func TestTools_UploadFilesStreamOrder(t *testing.T) {
+	request := multipartRequest(t,
+		testPart{field: "file", fileName: "first.txt", data: []byte("first")},
+		testPart{field: "file", fileName: "second.txt", data: []byte("second")},
+	)
+
+	testTools := Tools{Storage: &MemoryStorage{}, StreamUploads: true}
+
+	uploadedFiles, err := testTools.UploadFiles(request, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(uploadedFiles) != 2 || uploadedFiles[0].NewFileName != "first.txt" || uploadedFiles[1].FileSize != 6 {
+		t.Errorf("unexpected uploaded files: %+v %+v", uploadedFiles[0], uploadedFiles[1])
+	}
+}
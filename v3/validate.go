@@ -0,0 +1,358 @@
+package gotoolkit
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// FieldError describes a single field that failed validation. Field is the path of the field as
+// it appears in JSON, such as "address.city" or "photos[2].caption".
+type FieldError struct {
+	Field   string
+	Rule    string
+	Message string
+}
+
+// ValidationError is returned by Validate, and by ReadJSON when Tools.ValidateJSON is set. It
+// lists every field that failed validation, in the order they appear in the struct.
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, f := range e.Errors {
+		messages[i] = f.Field + " " + f.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Fields returns the message for each failing field, keyed by the path of the field
+func (e *ValidationError) Fields() map[string]string {
+	fields := make(map[string]string, len(e.Errors))
+	for _, f := range e.Errors {
+		fields[f.Field] = f.Message
+	}
+	return fields
+}
+
+func (e *ValidationError) add(field, rule, message string) {
+	e.Errors = append(e.Errors, FieldError{Field: field, Rule: rule, Message: message})
+}
+
+// Validate checks data, which must be a struct or a pointer to one, against the rules in the
+// `validate` tags of its fields. The rules are separated by commas:
+//
+//	required    the value must not be empty
+//	omitempty   skip the remaining rules when the value is empty
+//	min=n       numbers must be at least n; strings, slices and maps must have at least n elements
+//	max=n       as min, but an upper limit
+//	len=n       strings, slices and maps must have exactly n elements
+//	oneof=a b   the value must be one of the space separated options
+//	email       the value must be an email address
+//	lat, lng    the value must be a latitude or longitude in degrees
+//	dive        apply the rules that follow to every element of a slice
+//	regex=re    the value must match the regular expression re, which takes the rest of the tag
+//
+// Nested structs, and structs in slices, are always validated. If any field fails, a
+// *ValidationError listing every failing field is returned. Tags that cannot be understood return
+// an ordinary error.
+func (t *Tools) Validate(data any) error {
+	v := reflect.ValueOf(data)
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var errs ValidationError
+	if err := validateStruct(v, "", &errs); err != nil {
+		return err
+	}
+	if len(errs.Errors) > 0 {
+		return &errs
+	}
+
+	return nil
+}
+
+func validateStruct(v reflect.Value, prefix string, errs *ValidationError) error {
+	typ := v.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+
+		// embedded structs without a JSON name are flattened, as encoding/json does
+		if f.Anonymous && name == "" && indirect(v.Field(i)).Kind() == reflect.Struct {
+			if fv := indirect(v.Field(i)); fv.IsValid() {
+				if err := validateStruct(fv, prefix, errs); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+
+		if err := validateValue(v.Field(i), name, splitRules(f.Tag.Get("validate")), errs); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateValue applies rules to v. Only the first failing rule of a field is reported.
+func validateValue(v reflect.Value, field string, rules []string, errs *ValidationError) error {
+	for i, rule := range rules {
+		name, param, _ := strings.Cut(rule, "=")
+
+		switch name {
+		case "omitempty":
+			if isEmpty(v) {
+				return nil
+			}
+
+		case "required":
+			if isEmpty(v) {
+				errs.add(field, name, "is required")
+				return nil
+			}
+
+		case "dive":
+			v = indirect(v)
+			if !v.IsValid() {
+				return nil
+			}
+			if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+				return fmt.Errorf("validate: dive on %s, which is not a slice", field)
+			}
+			for j := 0; j < v.Len(); j++ {
+				if err := validateValue(v.Index(j), fmt.Sprintf("%s[%d]", field, j), rules[i+1:], errs); err != nil {
+					return err
+				}
+			}
+			return nil
+
+		default:
+			rv := indirect(v)
+			if !rv.IsValid() {
+				return nil
+			}
+			message, err := checkRule(rv, name, param)
+			if err != nil {
+				return fmt.Errorf("validate: %s: %w", field, err)
+			}
+			if message != "" {
+				errs.add(field, name, message)
+				return nil
+			}
+		}
+	}
+
+	v = indirect(v)
+	switch {
+	case !v.IsValid():
+	case v.Kind() == reflect.Struct:
+		return validateStruct(v, field, errs)
+	case v.Kind() == reflect.Slice || v.Kind() == reflect.Array:
+		elem := v.Type().Elem()
+		if elem.Kind() == reflect.Pointer {
+			elem = elem.Elem()
+		}
+		if elem.Kind() != reflect.Struct {
+			return nil
+		}
+		for j := 0; j < v.Len(); j++ {
+			if err := validateValue(v.Index(j), fmt.Sprintf("%s[%d]", field, j), nil, errs); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkRule applies a single rule to v, and returns the message to report if it fails
+func checkRule(v reflect.Value, rule, param string) (string, error) {
+	switch rule {
+	case "min", "max", "len":
+		limit, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			return "", fmt.Errorf("%s needs a number, not %q", rule, param)
+		}
+		return checkSize(v, rule, param, limit)
+
+	case "oneof":
+		value := fmt.Sprint(v.Interface())
+		options := strings.Fields(param)
+		for _, option := range options {
+			if value == option {
+				return "", nil
+			}
+		}
+		return "must be one of: " + strings.Join(options, ", "), nil
+
+	case "email":
+		if v.Kind() != reflect.String {
+			return "", fmt.Errorf("email needs a string, not %s", v.Kind())
+		}
+		addr, err := mail.ParseAddress(v.String())
+		if err != nil || addr.Address != v.String() {
+			return "must be a valid email address", nil
+		}
+
+	case "regex":
+		if v.Kind() != reflect.String {
+			return "", fmt.Errorf("regex needs a string, not %s", v.Kind())
+		}
+		re, err := compileRule(param)
+		if err != nil {
+			return "", err
+		}
+		if !re.MatchString(v.String()) {
+			return "is not in the expected format", nil
+		}
+
+	case "lat", "lng":
+		var degrees float64
+		switch {
+		case v.CanFloat():
+			degrees = v.Float()
+		case v.CanInt():
+			degrees = float64(v.Int())
+		default:
+			return "", fmt.Errorf("%s needs a number, not %s", rule, v.Kind())
+		}
+		if rule == "lat" && (degrees < -90 || degrees > 90) {
+			return "must be a valid latitude, between -90 and 90", nil
+		}
+		if rule == "lng" && (degrees < -180 || degrees > 180) {
+			return "must be a valid longitude, between -180 and 180", nil
+		}
+
+	default:
+		return "", fmt.Errorf("unknown rule %q", rule)
+	}
+
+	return "", nil
+}
+
+// checkSize applies a min, max or len rule to v
+func checkSize(v reflect.Value, rule, param string, limit float64) (string, error) {
+	var size float64
+	var unit string
+
+	switch v.Kind() {
+	case reflect.String:
+		size, unit = float64(utf8.RuneCountInString(v.String())), " characters"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		size, unit = float64(v.Len()), " items"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		size = float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		size = float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		size = v.Float()
+	default:
+		return "", fmt.Errorf("%s cannot be used with %s", rule, v.Kind())
+	}
+
+	switch {
+	case rule == "len" && unit == "":
+		return "", fmt.Errorf("len cannot be used with %s", v.Kind())
+	case rule == "len" && size != limit:
+		return "must have exactly " + param + unit, nil
+	case rule == "min" && size < limit:
+		if unit == "" {
+			return "must be at least " + param, nil
+		}
+		return "must have at least " + param + unit, nil
+	case rule == "max" && size > limit:
+		if unit == "" {
+			return "must be at most " + param, nil
+		}
+		return "must have at most " + param + unit, nil
+	}
+
+	return "", nil
+}
+
+// splitRules splits a validate tag into its rules. A regex rule takes the rest of the tag, so
+// that its expression may contain commas.
+func splitRules(tag string) []string {
+	var rules []string
+	for tag != "" {
+		if strings.HasPrefix(tag, "regex=") {
+			return append(rules, tag)
+		}
+		rule, rest, _ := strings.Cut(tag, ",")
+		if rule = strings.TrimSpace(rule); rule != "" {
+			rules = append(rules, rule)
+		}
+		tag = strings.TrimSpace(rest)
+	}
+	return rules
+}
+
+// isEmpty reports whether v holds the zero value, or is an empty string, slice or map
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map, reflect.String:
+		return v.Len() == 0
+	case reflect.Invalid:
+		return true
+	}
+	return v.IsZero()
+}
+
+// indirect follows pointers and interfaces, returning the zero Value for nil
+func indirect(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+var ruleRegexps sync.Map
+
+// compileRule compiles the expression of a regex rule, caching the result
+func compileRule(expr string) (*regexp.Regexp, error) {
+	if re, ok := ruleRegexps.Load(expr); ok {
+		return re.(*regexp.Regexp), nil
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, errors.New("regex: " + err.Error())
+	}
+	ruleRegexps.Store(expr, re)
+
+	return re, nil
+}
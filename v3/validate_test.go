@@ -0,0 +1,196 @@
+package gotoolkit
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testAddress struct {
+	Street string `json:"street" validate:"required"`
+	City   string `json:"city" validate:"required,max=20"`
+}
+
+type testListing struct {
+	Title     string         `json:"title" validate:"required,min=3,max=50"`
+	Phone     string         `json:"phone" validate:"required,regex=^\\+?[0-9 ]{6,15}$"`
+	Email     string         `json:"email" validate:"omitempty,email"`
+	Type      string         `json:"type" validate:"oneof=house flat land"`
+	Rooms     int            `json:"rooms" validate:"min=1,max=20"`
+	Lat       float64        `json:"lat" validate:"lat"`
+	Lng       float64        `json:"lng" validate:"lng"`
+	Reference string         `json:"reference" validate:"omitempty,len=8"`
+	Tags      []string       `json:"tags" validate:"max=3,dive,min=2"`
+	Address   *testAddress   `json:"address" validate:"required"`
+	Rooms2    []testAddress  `json:"others"`
+	Notes     *string        `json:"notes" validate:"min=5"`
+	Ignored   string         `json:"-" validate:"required"`
+	Extra     map[string]int `json:"extra" validate:"omitempty,min=1"`
+}
+
+func validListing() testListing {
+	return testListing{
+		Title:   "Sea view flat",
+		Phone:   "+33 1 23 45 67",
+		Type:    "flat",
+		Rooms:   3,
+		Lat:     43.7,
+		Lng:     7.26,
+		Tags:    []string{"sea", "view"},
+		Address: &testAddress{Street: "1 Promenade", City: "Nice"},
+	}
+}
+
+var validateTests = []struct {
+	name     string
+	change   func(l *testListing)
+	expected map[string]string
+}{
+	{name: "valid", change: func(l *testListing) {}},
+	{
+		name:   "required",
+		change: func(l *testListing) { l.Title = ""; l.Phone = ""; l.Address = nil },
+		expected: map[string]string{
+			"title":   "is required",
+			"phone":   "is required",
+			"address": "is required",
+		},
+	},
+	{
+		name: "bounds",
+		change: func(l *testListing) {
+			l.Title = "ab"
+			l.Rooms = 0
+			l.Lat = 91
+			l.Lng = -180.5
+			l.Tags = []string{"a", "b", "c", "d"}
+		},
+		expected: map[string]string{
+			"title": "must have at least 3 characters",
+			"rooms": "must be at least 1",
+			"lat":   "must be a valid latitude, between -90 and 90",
+			"lng":   "must be a valid longitude, between -180 and 180",
+			"tags":  "must have at most 3 items",
+		},
+	},
+	{
+		name: "formats",
+		change: func(l *testListing) {
+			l.Phone = "call me"
+			l.Email = "Bob <bob@example.com>"
+			l.Type = "castle"
+			l.Reference = "ABC"
+		},
+		expected: map[string]string{
+			"phone":     "is not in the expected format",
+			"email":     "must be a valid email address",
+			"type":      "must be one of: house, flat, land",
+			"reference": "must have exactly 8 characters",
+		},
+	},
+	{
+		name: "nested and dive",
+		change: func(l *testListing) {
+			l.Tags = []string{"sea", "x"}
+			l.Address.City = ""
+			l.Rooms2 = []testAddress{{Street: "a", City: "b"}, {City: "c"}}
+			notes := "hi"
+			l.Notes = &notes
+		},
+		expected: map[string]string{
+			"tags[1]":          "must have at least 2 characters",
+			"address.city":     "is required",
+			"others[1].street": "is required",
+			"notes":            "must have at least 5 characters",
+		},
+	},
+}
+
+func TestTools_Validate(t *testing.T) {
+	var testTools Tools
+
+	for _, e := range validateTests {
+		l := validListing()
+		e.change(&l)
+
+		err := testTools.Validate(&l)
+		if e.expected == nil {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", e.name, err)
+			}
+			continue
+		}
+
+		var validationErr *ValidationError
+		if !errors.As(err, &validationErr) {
+			t.Errorf("%s: expected a *ValidationError, got %v", e.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(validationErr.Fields(), e.expected) {
+			t.Errorf("%s: expected %v, got %v", e.name, e.expected, validationErr.Fields())
+		}
+	}
+}
+
+func TestTools_ValidateBadTag(t *testing.T) {
+	var testTools Tools
+
+	var tests = []any{
+		&struct {
+			A string `validate:"unknown"`
+		}{},
+		&struct {
+			A string `validate:"min=abc"`
+		}{},
+		&struct {
+			A int `validate:"email"`
+		}{},
+		&struct {
+			A bool `validate:"dive"`
+		}{},
+	}
+
+	for i, data := range tests {
+		err := testTools.Validate(data)
+		var validationErr *ValidationError
+		if err == nil || errors.As(err, &validationErr) {
+			t.Errorf("test %d: expected a plain error, got %v", i, err)
+		}
+	}
+}
+
+func TestTools_ReadJSONValidate(t *testing.T) {
+	testTools := Tools{ValidateJSON: true}
+
+	var data struct {
+		Name  string  `json:"name" validate:"required"`
+		Lat   float64 `json:"lat" validate:"lat"`
+		Email string  `json:"email" validate:"email"`
+	}
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{"lat": 100, "email": "someone@example.com"}`))
+	err := testTools.ReadJSON(httptest.NewRecorder(), req, &data)
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) || len(validationErr.Errors) != 2 {
+		t.Fatalf("expected two failing fields, got %v", err)
+	}
+	if err.Error() != "name is required; lat must be a valid latitude, between -90 and 90" {
+		t.Errorf("unexpected message %s", err)
+	}
+
+	rr := httptest.NewRecorder()
+	_ = testTools.ErrorJSON(rr, err)
+
+	var payload JSONResponse
+	if err := json.NewDecoder(rr.Body).Decode(&payload); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Code != http.StatusBadRequest || !payload.Error || payload.Errors["name"] != "is required" || payload.Errors["lat"] == "" {
+		t.Errorf("unexpected response %d %+v", rr.Code, payload)
+	}
+}
@@ -0,0 +1,149 @@
+package gotoolkit
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// ZipEntry is one file in an archive sent by DownloadZip. The content is read from the local file
+// Path or, when Path is empty, from the object Key in t.Storage. Name is the path of the file
+// inside the archive, and defaults to the last element of Path or Key.
+type ZipEntry struct {
+	Name string
+	Path string
+	Key  string
+}
+
+// storedExtensions are formats that are already compressed, so they are stored in an archive as
+// they are rather than compressed again
+var storedExtensions = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true,
+	".zip": true, ".gz": true, ".tgz": true, ".bz2": true, ".xz": true, ".7z": true, ".rar": true,
+	".mp3": true, ".mp4": true, ".mov": true, ".docx": true, ".xlsx": true, ".pptx": true,
+}
+
+// DownloadZip sends a ZIP archive of entries to the client, named displayName. The archive is
+// written as it is built, so nothing is buffered in memory or on disk. Every entry is checked
+// before anything is sent; if one cannot be found a 404 response is sent, and the error returned.
+// Once the archive has started it cannot be replaced with an error response, so a failure part
+// way through, such as the client going away, ends the response early and returns the error.
+func (t *Tools) DownloadZip(w http.ResponseWriter, r *http.Request, displayName string, entries []ZipEntry) error {
+	ctx := r.Context()
+
+	names := make([]string, len(entries))
+	modTimes := make([]time.Time, len(entries))
+	for i, e := range entries {
+		name, modTime, err := t.statZipEntry(ctx, e)
+		if err != nil {
+			if errors.Is(err, ErrObjectNotFound) || errors.Is(err, os.ErrNotExist) {
+				http.NotFound(w, r)
+			} else {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			}
+			return err
+		}
+		names[i], modTimes[i] = name, modTime
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", contentDisposition(false, displayName))
+	w.WriteHeader(http.StatusOK)
+
+	zw := zip.NewWriter(w)
+	for i, e := range entries {
+		if err := t.writeZipEntry(ctx, zw, e, names[i], modTimes[i]); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// statZipEntry checks that the content of e exists, and returns its name in the archive and its
+// modification time
+func (t *Tools) statZipEntry(ctx context.Context, e ZipEntry) (string, time.Time, error) {
+	name := e.Name
+	var modTime time.Time
+
+	if e.Path != "" {
+		fi, err := os.Stat(e.Path)
+		if err != nil {
+			return "", modTime, err
+		}
+		if fi.IsDir() {
+			return "", modTime, fmt.Errorf("%s is a directory", e.Path)
+		}
+		if name == "" {
+			name = fi.Name()
+		}
+		modTime = fi.ModTime()
+	} else {
+		if t.Storage == nil {
+			return "", modTime, errors.New("no storage to read zip entries from")
+		}
+		obj, err := t.Storage.Stat(ctx, e.Key)
+		if err != nil {
+			return "", modTime, err
+		}
+		if name == "" {
+			name = path.Base(obj.Key)
+		}
+		modTime = obj.ModTime
+	}
+
+	name = strings.ReplaceAll(name, "\\", "/")
+	if !safeArchivePath(name) {
+		return "", modTime, fmt.Errorf("zip entry name %q is not permitted", name)
+	}
+
+	return path.Clean(name), modTime, nil
+}
+
+// writeZipEntry copies the content of e into zw as name
+func (t *Tools) writeZipEntry(ctx context.Context, zw *zip.Writer, e ZipEntry, name string, modTime time.Time) error {
+	var rc io.ReadCloser
+	var err error
+	if e.Path != "" {
+		rc, err = os.Open(e.Path)
+	} else {
+		rc, _, err = t.Storage.Get(ctx, e.Key)
+	}
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	header := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modTime}
+	if storedExtensions[strings.ToLower(path.Ext(name))] {
+		header.Method = zip.Store
+	}
+
+	fw, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(fw, &contextReader{ctx: ctx, r: rc})
+	return err
+}
+
+// contextReader stops reading once ctx is done, such as when the client of a request disconnects
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
@@ -0,0 +1,106 @@
+package gotoolkit
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTools_DownloadZip(t *testing.T) {
+	store := &MemoryStorage{}
+	_, _ = store.Put(context.Background(), "reports/valuation.txt", strings.NewReader("valuation report"), -1, "text/plain")
+	testTools := Tools{Storage: store}
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/", nil)
+	err := testTools.DownloadZip(rr, req, "12 Rue Été.zip", []ZipEntry{
+		{Name: "photos/front.jpg", Path: "./testdata/pic.jpg"},
+		{Path: "./testdata/img.png"},
+		{Name: "Évaluation.txt", Key: "reports/valuation.txt"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr.Header().Get("Content-Type") != "application/zip" {
+		t.Errorf("wrong content type %s", rr.Header().Get("Content-Type"))
+	}
+	if got := rr.Header().Get("Content-Disposition"); got != `attachment; filename="12 Rue _t_.zip"; filename*=UTF-8''12%20Rue%20%C3%89t%C3%A9.zip` {
+		t.Errorf("wrong content disposition %s", got)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string][]byte{
+		"photos/front.jpg": readTestFile(t, "./testdata/pic.jpg"),
+		"img.png":          readTestFile(t, "./testdata/img.png"),
+		"Évaluation.txt":   []byte("valuation report"),
+	}
+	if len(zr.File) != len(expected) {
+		t.Fatalf("expected %d entries, got %d", len(expected), len(zr.File))
+	}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+
+		if !bytes.Equal(data, expected[f.Name]) {
+			t.Errorf("%s: wrong content", f.Name)
+		}
+		if strings.HasSuffix(f.Name, ".txt") != (f.Method == zip.Deflate) {
+			t.Errorf("%s: unexpected compression method %d", f.Name, f.Method)
+		}
+	}
+}
+
+func TestTools_DownloadZipErrors(t *testing.T) {
+	testTools := Tools{Storage: &MemoryStorage{}}
+
+	var tests = []struct {
+		name    string
+		entries []ZipEntry
+		status  int
+	}{
+		{name: "missing file", entries: []ZipEntry{{Path: "./testdata/pic.jpg"}, {Path: "./testdata/missing.jpg"}}, status: http.StatusNotFound},
+		{name: "missing object", entries: []ZipEntry{{Key: "missing.txt"}}, status: http.StatusNotFound},
+		{name: "directory", entries: []ZipEntry{{Path: "./testdata"}}, status: http.StatusInternalServerError},
+		{name: "unsafe name", entries: []ZipEntry{{Name: "../evil.jpg", Path: "./testdata/pic.jpg"}}, status: http.StatusInternalServerError},
+	}
+
+	for _, e := range tests {
+		rr := httptest.NewRecorder()
+		err := testTools.DownloadZip(rr, httptest.NewRequest("GET", "/", nil), "all.zip", e.entries)
+		if err == nil {
+			t.Errorf("%s: error expected but none received", e.name)
+		}
+		if rr.Code != e.status || rr.Header().Get("Content-Type") == "application/zip" {
+			t.Errorf("%s: expected status %d, got %d", e.name, e.status, rr.Code)
+		}
+	}
+}
+
+func TestTools_DownloadZipClientGone(t *testing.T) {
+	var testTools Tools
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+	err := testTools.DownloadZip(rr, req, "all.zip", []ZipEntry{{Path: "./testdata/pic.jpg"}, {Path: "./testdata/img.png"}})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}